@@ -0,0 +1,241 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when Advance is called.
+// Timers and tickers fire synchronously inside Advance
+// in the order of their deadlines.
+type Fake struct {
+	mu   *sync.Mutex
+	cond *sync.Cond
+
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a timer or a ticker of the fake clock.
+type fakeWaiter struct {
+	fake *Fake
+
+	ch       chan time.Time
+	deadline time.Time
+	// period is zero for timers
+	period time.Duration
+}
+
+// NewFake creates a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	mu := &sync.Mutex{}
+
+	return &Fake{
+		mu:   mu,
+		cond: sync.NewCond(mu),
+		now:  now,
+	}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		// fail fast, as time.NewTicker does
+		panic("non-positive interval for NewTicker")
+	}
+
+	w := &fakeWaiter{
+		fake:   f,
+		ch:     make(chan time.Time, 1),
+		period: d,
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.schedule(w, d)
+
+	return fakeTicker{w}
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	w := &fakeWaiter{
+		fake: f,
+		ch:   make(chan time.Time, 1),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.schedule(w, d)
+	f.fire(f.now)
+
+	return fakeTimer{w}
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// Advance moves the clock forward and fires timers and tickers
+// whose deadlines have come.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fire(f.now.Add(d))
+}
+
+// BlockUntil blocks until at least n timers and tickers are active.
+// It lets a test wait until the code under test has armed its timers
+// before advancing the clock.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// Waiters returns the number of active timers and tickers.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
+
+// fire moves the clock to until firing due waiters in deadline order.
+// It must be called with f.mu held.
+func (f *Fake) fire(until time.Time) {
+	for len(f.waiters) > 0 && !f.waiters[0].deadline.After(until) {
+		w := f.waiters[0]
+		f.now = w.deadline
+
+		// like real timers, a value is dropped if the previous one is not received
+		select {
+		case w.ch <- f.now:
+		default:
+		}
+
+		f.unschedule(w)
+
+		if w.period > 0 {
+			f.schedule(w, w.period)
+		}
+	}
+
+	if until.After(f.now) {
+		f.now = until
+	}
+}
+
+// schedule must be called with f.mu held.
+func (f *Fake) schedule(w *fakeWaiter, d time.Duration) {
+	w.deadline = f.now.Add(d)
+
+	i, _ := slices.BinarySearchFunc(
+		f.waiters,
+		w.deadline,
+		func(w *fakeWaiter, deadline time.Time) int {
+			// waiters with equal deadlines fire in scheduling order
+			if w.deadline.After(deadline) {
+				return 1
+			}
+
+			return -1
+		},
+	)
+
+	f.waiters = slices.Insert(f.waiters, i, w)
+	f.cond.Broadcast()
+}
+
+// unschedule reports whether the waiter was active.
+// It must be called with f.mu held.
+func (f *Fake) unschedule(w *fakeWaiter) bool {
+	i := slices.Index(f.waiters, w)
+	if i < 0 {
+		return false
+	}
+
+	f.waiters = slices.Delete(f.waiters, i, i+1)
+	f.cond.Broadcast()
+
+	return true
+}
+
+// stop must be called with f.mu held.
+func (w *fakeWaiter) stop() bool {
+	active := w.fake.unschedule(w)
+
+	// drain a stale value
+	select {
+	case <-w.ch:
+	default:
+	}
+
+	return active
+}
+
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t fakeTicker) Stop() {
+	t.fake.mu.Lock()
+	defer t.fake.mu.Unlock()
+
+	t.stop()
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+
+	t.fake.mu.Lock()
+	defer t.fake.mu.Unlock()
+
+	t.stop()
+	t.period = d
+	t.fake.schedule(t.fakeWaiter, d)
+}
+
+type fakeTimer struct {
+	*fakeWaiter
+}
+
+func (t fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t fakeTimer) Stop() bool {
+	t.fake.mu.Lock()
+	defer t.fake.mu.Unlock()
+
+	return t.stop()
+}
+
+func (t fakeTimer) Reset(d time.Duration) bool {
+	t.fake.mu.Lock()
+	defer t.fake.mu.Unlock()
+
+	active := t.stop()
+	t.fake.schedule(t.fakeWaiter, d)
+	t.fake.fire(t.fake.now)
+
+	return active
+}
@@ -0,0 +1,106 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func received(ch <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-ch:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(start)
+	timer := clk.NewTimer(time.Second)
+
+	clk.Advance(999 * time.Millisecond)
+
+	_, ok := received(timer.C())
+	assert.False(t, ok)
+
+	clk.Advance(time.Millisecond)
+
+	got, ok := received(timer.C())
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(time.Second), got)
+	assert.Equal(t, 0, clk.Waiters())
+}
+
+func TestFakeTimerReset(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(start)
+	timer := clk.NewTimer(time.Second)
+
+	clk.Advance(time.Second)
+
+	// the fired value is drained by reset
+	assert.False(t, timer.Reset(time.Second))
+
+	_, ok := received(timer.C())
+	assert.False(t, ok)
+
+	assert.True(t, timer.Stop())
+
+	clk.Advance(time.Hour)
+
+	_, ok = received(timer.C())
+	assert.False(t, ok)
+}
+
+func TestFakeTicker(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(start)
+	ticker := clk.NewTicker(time.Second)
+
+	clk.Advance(time.Second)
+
+	got, ok := received(ticker.C())
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(time.Second), got)
+
+	// ticks are dropped while the previous one is not received
+	clk.Advance(3 * time.Second)
+
+	got, ok = received(ticker.C())
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(2*time.Second), got)
+
+	_, ok = received(ticker.C())
+	assert.False(t, ok)
+
+	ticker.Stop()
+	assert.Equal(t, 0, clk.Waiters())
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(start)
+	done := make(chan struct{})
+
+	go func() {
+		<-clk.After(time.Minute)
+		close(done)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+
+	<-done
+
+	assert.Equal(t, start.Add(time.Minute), clk.Now())
+}
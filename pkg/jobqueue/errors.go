@@ -0,0 +1,10 @@
+package jobqueue
+
+import "github.com/pkg/errors"
+
+var (
+	ErrAlreadyRunning   = errors.New("queue is already running")
+	ErrDuplicateJob     = errors.New("job with the same unique key is already queued")
+	ErrDuplicateHandler = errors.New("handler of the kind is already registered")
+	ErrLeaseLost        = errors.New("job lease expired and the job was taken by another worker")
+)
@@ -0,0 +1,210 @@
+// Package jobqueue implements a persistent job queue on top of Postgres.
+//
+// Jobs are claimed with FOR UPDATE SKIP LOCKED, so any number of processes
+// may work on the same table. A claimed job is hidden from other workers
+// for the visibility timeout; if the worker does not finish it in time,
+// the job is claimed again.
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// DefaultTable is the name of the jobs table used by default.
+const DefaultTable = "jobs"
+
+// States of a job.
+const (
+	StatePending = "pending"
+	StateRunning = "running"
+	// StateDead is the state of a job that has exhausted its attempts.
+	// Dead jobs are kept for inspection and are not run again.
+	StateDead = "dead"
+)
+
+// Schema returns the DDL of the jobs table.
+// The name is inserted as is, so it must come from trusted configuration.
+// Completed jobs are deleted from the table.
+func Schema(table string) string {
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
+	id           bigserial   PRIMARY KEY,
+	kind         text        NOT NULL,
+	args         jsonb       NOT NULL,
+	state        text        NOT NULL DEFAULT 'pending'
+		CHECK (state IN ('pending', 'running', 'dead')),
+	priority     int         NOT NULL DEFAULT 0,
+	run_at       timestamptz NOT NULL DEFAULT now(),
+	unique_key   text,
+	attempts     int         NOT NULL DEFAULT 0,
+	max_attempts int         NOT NULL,
+	locked_until timestamptz,
+	last_error   text,
+	created_at   timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS %[1]s_ready_idx
+	ON %[1]s (priority DESC, run_at, id) WHERE state IN ('pending', 'running');
+
+CREATE UNIQUE INDEX IF NOT EXISTS %[1]s_unique_idx
+	ON %[1]s (unique_key) WHERE unique_key IS NOT NULL AND state IN ('pending', 'running');
+`, table)
+}
+
+// Job is a job passed to a handler.
+type Job[T any] struct {
+	ID       int64
+	Kind     string
+	Args     T
+	Priority int
+	// Attempt is the number of the current attempt starting from 1.
+	Attempt     int
+	MaxAttempts int
+	CreatedAt   time.Time
+}
+
+// Handler runs a job.
+// The context carries the transaction in which the job is completed,
+// so writes made through Transactor.ExtractTx are committed
+// only together with the job. If the handler fails,
+// the writes are rolled back and the job is retried with backoff.
+type Handler[T any] func(ctx context.Context, job Job[T]) error
+
+type enqueueParams struct {
+	runAt       time.Time
+	priority    int
+	uniqueKey   string
+	maxAttempts int
+}
+
+// EnqueueOption sets optional parameters of an enqueued job.
+type EnqueueOption func(*enqueueParams)
+
+// WithRunAt delays the job until the given time.
+func WithRunAt(runAt time.Time) EnqueueOption {
+	return func(p *enqueueParams) {
+		p.runAt = runAt
+	}
+}
+
+// WithPriority sets the priority of the job.
+// Ready jobs with higher priority run first. Default is 0.
+func WithPriority(priority int) EnqueueOption {
+	return func(p *enqueueParams) {
+		p.priority = priority
+	}
+}
+
+// WithUniqueKey makes the job unique among pending and running jobs.
+// Enqueue returns ErrDuplicateJob if such a job already exists.
+func WithUniqueKey(key string) EnqueueOption {
+	return func(p *enqueueParams) {
+		p.uniqueKey = key
+	}
+}
+
+// WithMaxAttempts overrides Config.MaxAttempts for the job.
+func WithMaxAttempts(n int) EnqueueOption {
+	return func(p *enqueueParams) {
+		p.maxAttempts = n
+	}
+}
+
+// Kind is a kind of jobs with arguments of type T.
+// Arguments are stored as JSON.
+type Kind[T any] struct {
+	queue *Queue
+	name  string
+}
+
+func NewKind[T any](queue *Queue, name string) Kind[T] {
+	return Kind[T]{
+		queue: queue,
+		name:  name,
+	}
+}
+
+// Enqueue stores a job and returns its id.
+// If there is a transaction in context, the job is stored in it
+// and becomes visible to workers only after commit.
+func (k Kind[T]) Enqueue(ctx context.Context, args T, opts ...EnqueueOption) (int64, error) {
+	params := enqueueParams{
+		maxAttempts: k.queue.config.MaxAttempts,
+	}
+
+	for _, opt := range opts {
+		opt(&params)
+	}
+
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return 0, errors.Wrapf(err, "marshal args of %q", k.name)
+	}
+
+	var runAt *time.Time
+	if !params.runAt.IsZero() {
+		runAt = &params.runAt
+	}
+
+	var id int64
+
+	// the insert must not be routed to a replica outside of transaction
+	ctx = transactor.ForcePrimary(ctx)
+
+	err = k.queue.transactor.ExtractTx(ctx).QueryRow(
+		ctx,
+		fmt.Sprintf(`
+INSERT INTO %s (kind, args, priority, run_at, unique_key, max_attempts)
+VALUES ($1, $2, $3, coalesce($4, now()), NULLIF($5, ''), $6)
+ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL AND state IN ('pending', 'running')
+DO NOTHING
+RETURNING id`,
+			k.queue.config.Table,
+		),
+		k.name,
+		raw,
+		params.priority,
+		runAt,
+		params.uniqueKey,
+		params.maxAttempts,
+	).Scan(&id)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errors.Wrapf(ErrDuplicateJob, "%q", params.uniqueKey)
+	}
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "enqueue %q", k.name)
+	}
+
+	return id, nil
+}
+
+// Handle registers the handler of the kind.
+// Workers claim only jobs of kinds with registered handlers,
+// so it must be called before the queue is started.
+func (k Kind[T]) Handle(handler Handler[T]) error {
+	return k.queue.register(k.name, func(ctx context.Context, job claimedJob) error {
+		typed := Job[T]{
+			ID:          job.id,
+			Kind:        k.name,
+			Priority:    job.priority,
+			Attempt:     job.attempt,
+			MaxAttempts: job.maxAttempts,
+			CreatedAt:   job.createdAt,
+		}
+
+		if err := json.Unmarshal(job.args, &typed.Args); err != nil {
+			return errors.Wrapf(err, "unmarshal args of %q", k.name)
+		}
+
+		return handler(ctx, typed)
+	})
+}
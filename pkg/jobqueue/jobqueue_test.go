@@ -0,0 +1,242 @@
+package jobqueue_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/internal/pgtest"
+	"github.com/bogi-lyceya-44/common/pkg/jobqueue"
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type email struct {
+	To string `json:"to"`
+}
+
+// newQueue creates a jobs table and a side table unique for the test.
+func newQueue(t *testing.T, config jobqueue.Config) (*jobqueue.Queue, *transactor.Transactor, *pgxpool.Pool) {
+	t.Helper()
+
+	pool := pgtest.Connect(t)
+	config.Table = "jobs_" + strings.ToLower(t.Name())
+
+	_, err := pool.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			"DROP TABLE IF EXISTS %[1]s, %[1]s_sent; CREATE TABLE %[1]s_sent (addr text);",
+			config.Table,
+		)+jobqueue.Schema(config.Table),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = pool.Exec(
+			context.Background(),
+			fmt.Sprintf("DROP TABLE IF EXISTS %[1]s, %[1]s_sent", config.Table),
+		)
+	})
+
+	tr := transactor.New(pool)
+
+	return jobqueue.New(tr, config), tr, pool
+}
+
+// count runs a count query. It does not stop the test on errors,
+// since it is called by Eventually on another goroutine.
+func count(t *testing.T, pool *pgxpool.Pool, query string) int {
+	t.Helper()
+
+	var n int
+
+	assert.NoError(t, pool.QueryRow(context.Background(), query).Scan(&n))
+
+	return n
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	t.Parallel()
+
+	backoff := jobqueue.ExponentialBackoff(time.Second, 10*time.Second)
+
+	testCases := []struct {
+		attempt int
+		limit   time.Duration
+	}{
+		{attempt: 1, limit: time.Second},
+		{attempt: 2, limit: 2 * time.Second},
+		{attempt: 4, limit: 8 * time.Second},
+		{attempt: 5, limit: 10 * time.Second},
+		{attempt: 100, limit: 10 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.attempt), func(st *testing.T) {
+			st.Parallel()
+
+			for range 100 {
+				delay := backoff(tc.attempt)
+
+				assert.GreaterOrEqual(st, delay, time.Duration(0))
+				assert.LessOrEqual(st, delay, tc.limit)
+			}
+		})
+	}
+}
+
+func TestUniqueKey(t *testing.T) {
+	t.Parallel()
+
+	queue, _, _ := newQueue(t, jobqueue.Config{})
+	kind := jobqueue.NewKind[email](queue, "email")
+
+	_, err := kind.Enqueue(context.Background(), email{To: "a"}, jobqueue.WithUniqueKey("a"))
+	require.NoError(t, err)
+
+	_, err = kind.Enqueue(context.Background(), email{To: "a"}, jobqueue.WithUniqueKey("a"))
+	require.ErrorIs(t, err, jobqueue.ErrDuplicateJob)
+}
+
+func TestHandlerSharesTransaction(t *testing.T) {
+	t.Parallel()
+
+	queue, tr, pool := newQueue(t, jobqueue.Config{
+		PollInterval: 10 * time.Millisecond,
+		MaxAttempts:  3,
+		Backoff:      func(int) time.Duration { return 0 },
+	})
+	table := "jobs_" + strings.ToLower(t.Name())
+
+	kind := jobqueue.NewKind[email](queue, "email")
+
+	var (
+		mu       sync.Mutex
+		attempts []int
+	)
+
+	err := kind.Handle(func(ctx context.Context, job jobqueue.Job[email]) error {
+		mu.Lock()
+		attempts = append(attempts, job.Attempt)
+		mu.Unlock()
+
+		// the handler runs on a worker goroutine: a failed insert
+		// fails the attempt and breaks the attempts assertion below
+		_, err := tr.ExtractTx(ctx).Exec(ctx, "INSERT INTO "+table+"_sent VALUES ($1)", job.Args.To)
+		if err != nil {
+			return err
+		}
+
+		// the write is rolled back with the failed attempt
+		if job.Attempt < 2 {
+			return errors.New("smtp is down")
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = kind.Enqueue(context.Background(), email{To: "a@example.com"})
+	require.NoError(t, err)
+
+	require.NoError(t, queue.Start(context.Background()))
+
+	require.Eventually(t, func() bool {
+		return count(t, pool, "SELECT count(*) FROM "+table) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, queue.Stop(context.Background()))
+
+	assert.Equal(t, []int{1, 2}, attempts)
+	assert.Equal(t, 1, count(t, pool, "SELECT count(*) FROM "+table+"_sent"))
+}
+
+func TestDeadAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	queue, _, pool := newQueue(t, jobqueue.Config{
+		PollInterval: 10 * time.Millisecond,
+		Backoff:      func(int) time.Duration { return 0 },
+	})
+	table := "jobs_" + strings.ToLower(t.Name())
+
+	kind := jobqueue.NewKind[email](queue, "email")
+
+	err := kind.Handle(func(context.Context, jobqueue.Job[email]) error {
+		panic("boom")
+	})
+	require.NoError(t, err)
+
+	_, err = kind.Enqueue(context.Background(), email{To: "a"}, jobqueue.WithMaxAttempts(2))
+	require.NoError(t, err)
+
+	require.NoError(t, queue.Start(context.Background()))
+
+	require.Eventually(t, func() bool {
+		return count(t, pool, "SELECT count(*) FROM "+table+" WHERE state = 'dead' AND attempts = 2") == 1
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, queue.Stop(context.Background()))
+}
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	queue, _, _ := newQueue(t, jobqueue.Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+
+	kind := jobqueue.NewKind[email](queue, "email")
+
+	for i, priority := range []int{0, 10, 5} {
+		_, err := kind.Enqueue(
+			context.Background(),
+			email{To: fmt.Sprint(i)},
+			jobqueue.WithPriority(priority),
+		)
+		require.NoError(t, err)
+	}
+
+	// delayed jobs are not ready despite the priority
+	_, err := kind.Enqueue(
+		context.Background(),
+		email{To: "later"},
+		jobqueue.WithPriority(100),
+		jobqueue.WithRunAt(time.Now().Add(time.Hour)),
+	)
+	require.NoError(t, err)
+
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+
+	err = kind.Handle(func(_ context.Context, job jobqueue.Job[email]) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		order = append(order, job.Args.To)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, queue.Start(context.Background()))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(order) == 3
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, queue.Stop(context.Background()))
+
+	assert.Equal(t, []string{"1", "2", "0"}, order)
+}
@@ -0,0 +1,376 @@
+package jobqueue
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// Config configures a Queue. Zero fields are set to defaults.
+type Config struct {
+	// Table is the name of the jobs table. Default is DefaultTable.
+	Table string
+	// Workers is the number of jobs run concurrently. Default is 1.
+	Workers int
+	// PollInterval is the delay between polls that found no ready job.
+	// Default is 1s.
+	PollInterval time.Duration
+	// VisibilityTimeout is how long a claimed job is hidden from other workers.
+	// It is also the deadline of the handler. Default is 5m.
+	VisibilityTimeout time.Duration
+	// MaxAttempts is the number of attempts after which a failed job is dead.
+	// Default is 5.
+	MaxAttempts int
+	// Backoff returns the delay before the next attempt of a failed job.
+	// Default is ExponentialBackoff(time.Second, time.Hour).
+	Backoff func(attempt int) time.Duration
+	// OnError receives errors of polls and failed jobs.
+	OnError func(error)
+	// Clock is used for poll intervals. Default is clock.Real().
+	Clock clock.Clock
+}
+
+func (c *Config) setDefaults() {
+	if c.Table == "" {
+		c.Table = DefaultTable
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+
+	if c.VisibilityTimeout <= 0 {
+		c.VisibilityTimeout = 5 * time.Minute
+	}
+
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+
+	if c.Backoff == nil {
+		c.Backoff = ExponentialBackoff(time.Second, time.Hour)
+	}
+
+	if c.Clock == nil {
+		c.Clock = clock.Real()
+	}
+}
+
+// ExponentialBackoff returns a backoff with full jitter:
+// the delay is random up to base doubled on every attempt, capped by maxDelay.
+func ExponentialBackoff(base, maxDelay time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		backoff := base << (attempt - 1)
+		if backoff <= 0 || backoff > maxDelay {
+			backoff = maxDelay
+		}
+
+		if backoff <= 0 {
+			return 0
+		}
+
+		return rand.N(backoff + 1)
+	}
+}
+
+type handlerFunc func(ctx context.Context, job claimedJob) error
+
+// claimedJob is a job row taken by a worker.
+type claimedJob struct {
+	id          int64
+	kind        string
+	args        []byte
+	priority    int
+	attempt     int
+	maxAttempts int
+	createdAt   time.Time
+}
+
+// Queue runs jobs of registered kinds.
+type Queue struct {
+	transactor *transactor.Transactor
+	config     Config
+
+	mu       *sync.Mutex
+	handlers map[string]handlerFunc
+	running  bool
+	cancel   context.CancelFunc
+
+	wg *sync.WaitGroup
+}
+
+func New(tr *transactor.Transactor, config Config) *Queue {
+	config.setDefaults()
+
+	return &Queue{
+		transactor: tr,
+		config:     config,
+		mu:         &sync.Mutex{},
+		handlers:   make(map[string]handlerFunc),
+		wg:         &sync.WaitGroup{},
+	}
+}
+
+func (q *Queue) register(kind string, handler handlerFunc) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.handlers[kind]; ok {
+		return errors.Wrapf(ErrDuplicateHandler, "%q", kind)
+	}
+
+	q.handlers[kind] = handler
+
+	return nil
+}
+
+// Start starts the workers.
+// They stop claiming jobs when ctx is done or Stop is called.
+func (q *Queue) Start(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.running {
+		return ErrAlreadyRunning
+	}
+
+	ctx, q.cancel = context.WithCancel(ctx)
+	q.running = true
+
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	for range q.config.Workers {
+		q.wg.Add(1)
+
+		go func() {
+			defer q.wg.Done()
+			q.work(ctx, kinds)
+		}()
+	}
+
+	return nil
+}
+
+// Stop stops claiming jobs and waits for running ones
+// to finish until ctx is done.
+func (q *Queue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+
+	if q.running {
+		q.cancel()
+		q.running = false
+	}
+
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait for running jobs")
+	}
+}
+
+// work claims and runs jobs until ctx is done.
+func (q *Queue) work(ctx context.Context, kinds []string) {
+	for {
+		job, found, err := q.claim(ctx, kinds)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			q.reportError(err)
+		}
+
+		if found {
+			// a running job is finished even if the queue is stopped,
+			// it is bounded by the visibility timeout anyway
+			q.run(context.WithoutCancel(ctx), job)
+			continue
+		}
+
+		timer := q.config.Clock.NewTimer(q.config.PollInterval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
+
+// run runs the handler and completes the job in one transaction.
+// If anything fails, the job is retried or buried.
+func (q *Queue) run(ctx context.Context, job claimedJob) {
+	// the job was claimed again after the visibility timeout
+	// of its last attempt had expired
+	if job.attempt > job.maxAttempts {
+		q.fail(ctx, job, errors.New("visibility timeout expired"))
+		return
+	}
+
+	q.mu.Lock()
+	handler := q.handlers[job.kind]
+	q.mu.Unlock()
+
+	handlerCtx, cancel := context.WithTimeout(ctx, q.config.VisibilityTimeout)
+	defer cancel()
+
+	err := q.transactor.WithTx(handlerCtx, func(ctx context.Context) error {
+		if err := safeHandle(ctx, handler, job); err != nil {
+			return err
+		}
+
+		return q.complete(ctx, job)
+	})
+	if err != nil {
+		q.fail(ctx, job, err)
+	}
+}
+
+func safeHandle(ctx context.Context, handler handlerFunc, job claimedJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("job panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return handler(ctx, job)
+}
+
+func (q *Queue) reportError(err error) {
+	if err != nil && q.config.OnError != nil {
+		q.config.OnError(err)
+	}
+}
+
+// claim takes the most urgent ready job of the given kinds.
+// Jobs whose visibility timeout has expired are ready again.
+func (q *Queue) claim(ctx context.Context, kinds []string) (claimedJob, bool, error) {
+	var job claimedJob
+
+	// the claim is a write, it must not be routed to a replica
+	ctx = transactor.ForcePrimary(ctx)
+
+	err := q.transactor.ExtractTx(ctx).QueryRow(
+		ctx,
+		fmt.Sprintf(`
+WITH next AS (
+	SELECT id FROM %[1]s
+	WHERE kind = ANY($1) AND (
+		(state = 'pending' AND run_at <= now()) OR
+		(state = 'running' AND locked_until < now())
+	)
+	ORDER BY priority DESC, run_at, id
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+UPDATE %[1]s t
+SET state = 'running',
+	attempts = t.attempts + 1,
+	locked_until = now() + make_interval(secs => $2)
+FROM next
+WHERE t.id = next.id
+RETURNING t.id, t.kind, t.args, t.priority, t.attempts, t.max_attempts, t.created_at`,
+			q.config.Table,
+		),
+		kinds,
+		q.config.VisibilityTimeout.Seconds(),
+	).Scan(
+		&job.id,
+		&job.kind,
+		&job.args,
+		&job.priority,
+		&job.attempt,
+		&job.maxAttempts,
+		&job.createdAt,
+	)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return claimedJob{}, false, nil
+	}
+
+	if err != nil {
+		return claimedJob{}, false, errors.Wrap(err, "claim job")
+	}
+
+	return job, true, nil
+}
+
+// complete deletes the job in the transaction of the handler.
+// The attempt number fences off workers whose lease has expired.
+func (q *Queue) complete(ctx context.Context, job claimedJob) error {
+	tag, err := q.transactor.ExtractTx(ctx).Exec(
+		ctx,
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE id = $1 AND attempts = $2 AND state = 'running'",
+			q.config.Table,
+		),
+		job.id,
+		job.attempt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "complete job")
+	}
+
+	if tag.RowsAffected() == 0 {
+		return errors.Wrapf(ErrLeaseLost, "job %d", job.id)
+	}
+
+	return nil
+}
+
+// fail schedules the next attempt of the job or buries it
+// if the attempts are exhausted.
+func (q *Queue) fail(ctx context.Context, job claimedJob, jobErr error) {
+	jobErr = errors.Wrapf(jobErr, "job %d of %q, attempt %d", job.id, job.kind, job.attempt)
+	q.reportError(jobErr)
+
+	// the lease is taken by another worker, it is responsible for the job now
+	if errors.Is(jobErr, ErrLeaseLost) {
+		return
+	}
+
+	_, err := q.transactor.ExtractTx(ctx).Exec(
+		ctx,
+		fmt.Sprintf(`
+UPDATE %s
+SET state = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+	run_at = now() + make_interval(secs => $3),
+	locked_until = NULL,
+	last_error = $4
+WHERE id = $1 AND attempts = $2 AND state = 'running'`,
+			q.config.Table,
+		),
+		job.id,
+		job.attempt,
+		q.config.Backoff(job.attempt).Seconds(),
+		jobErr.Error(),
+	)
+
+	q.reportError(errors.Wrapf(err, "fail job %d", job.id))
+}
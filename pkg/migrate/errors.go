@@ -0,0 +1,11 @@
+package migrate
+
+import "github.com/pkg/errors"
+
+var (
+	ErrInvalidMigration = errors.New("invalid migration")
+	ErrChecksumMismatch = errors.New("applied migration was changed")
+	ErrNoDownMigration  = errors.New("migration has no down file")
+	ErrMissingMigration = errors.New("applied migration has no files")
+	ErrUnknownVersion   = errors.New("unknown migration version")
+)
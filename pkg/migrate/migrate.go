@@ -0,0 +1,364 @@
+// Package migrate applies versioned SQL migrations read from an fs.FS.
+//
+// Migrations are files named NNNN_name.up.sql and NNNN_name.down.sql,
+// where NNNN is the version. Applied versions are recorded in a table
+// with checksums of their up files, so changed migrations are detected.
+// Down files are not covered, so they can be added or fixed
+// after their migrations are applied.
+// Runs are serialized across processes with a Postgres advisory lock.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// DefaultTable is the name of the table of applied versions used by default.
+const DefaultTable = "schema_migrations"
+
+// Status is the state of one migration.
+type Status struct {
+	Version int64
+	Name    string
+	Applied bool
+	// AppliedAt is zero if the migration is not applied.
+	AppliedAt time.Time
+	// Changed is set if the up file differs from the applied one.
+	Changed bool
+	// Missing is set if the migration is applied but its files are absent,
+	// e.g. the database was migrated by a newer release.
+	Missing bool
+}
+
+type Option func(*Migrator)
+
+// WithTable sets the name of the table of applied versions.
+// The name is inserted as is, so it must come from trusted configuration.
+// Default is DefaultTable.
+func WithTable(table string) Option {
+	return func(m *Migrator) {
+		m.table = table
+	}
+}
+
+// Migrator applies and rolls back migrations.
+type Migrator struct {
+	transactor *transactor.Transactor
+	migrations []Migration
+	table      string
+}
+
+// New reads migrations from the root of fsys.
+// Use fs.Sub to read them from a directory of an embed.FS.
+func New(tr *transactor.Transactor, fsys fs.FS, opts ...Option) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{
+		transactor: tr,
+		migrations: migrations,
+		table:      DefaultTable,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m, nil
+}
+
+// Migrations returns the migrations sorted by version.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// applied is a row of the table of applied versions.
+type applied struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Status returns the state of the known and the applied migrations
+// sorted by version. It does not create the table of applied versions,
+// if there is none, every migration is pending.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	exists, err := m.tableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done map[int64]applied
+
+	if exists {
+		done, err = m.applied(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	known := make(map[int64]bool, len(m.migrations))
+
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+
+		status := Status{
+			Version: mig.Version,
+			Name:    mig.Name,
+		}
+
+		if record, ok := done[mig.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+			status.Changed = record.Checksum != mig.Checksum
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	for _, record := range done {
+		if known[record.Version] {
+			continue
+		}
+
+		statuses = append(statuses, Status{
+			Version:   record.Version,
+			Name:      record.Name,
+			Applied:   true,
+			AppliedAt: record.AppliedAt,
+			Missing:   true,
+		})
+	}
+
+	sortStatuses(statuses)
+
+	return statuses, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+
+	return m.UpTo(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// UpTo applies pending migrations with versions up to the given one
+// in ascending order. Fails with ErrChecksumMismatch before applying anything
+// if an applied migration was changed. Scripts marked with NoTransactionMarker
+// fail with transactor.ErrTransactionExists if ctx carries a transaction.
+func (m *Migrator) UpTo(ctx context.Context, version int64) error {
+	if !m.known(version) {
+		return errors.Wrapf(ErrUnknownVersion, "%d", version)
+	}
+
+	return m.locked(ctx, func(ctx context.Context, done map[int64]applied) error {
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+
+			if err := m.apply(ctx, mig, mig.Up, true); err != nil {
+				return errors.Wrapf(err, "up %d_%s", mig.Version, mig.Name)
+			}
+		}
+
+		return nil
+	})
+}
+
+// DownTo rolls back applied migrations with versions above the given one
+// in descending order. Version 0 rolls back all of them.
+// Fails with ErrMissingMigration or ErrNoDownMigration
+// before rolling back anything if a migration can not be rolled back.
+func (m *Migrator) DownTo(ctx context.Context, version int64) error {
+	if version != 0 && !m.known(version) {
+		return errors.Wrapf(ErrUnknownVersion, "%d", version)
+	}
+
+	return m.locked(ctx, func(ctx context.Context, done map[int64]applied) error {
+		var pending []Migration
+
+		for _, record := range done {
+			if record.Version <= version {
+				continue
+			}
+
+			mig, ok := m.find(record.Version)
+			if !ok {
+				return errors.Wrapf(ErrMissingMigration, "%d_%s", record.Version, record.Name)
+			}
+
+			if mig.Down == nil {
+				return errors.Wrapf(ErrNoDownMigration, "%d_%s", mig.Version, mig.Name)
+			}
+
+			pending = append(pending, mig)
+		}
+
+		sortMigrations(pending)
+
+		for i := len(pending) - 1; i >= 0; i-- {
+			mig := pending[i]
+
+			if err := m.apply(ctx, mig, *mig.Down, false); err != nil {
+				return errors.Wrapf(err, "down %d_%s", mig.Version, mig.Name)
+			}
+		}
+
+		return nil
+	})
+}
+
+// locked runs fn under the session lock of the table
+// with the applied versions verified against the files.
+func (m *Migrator) locked(
+	ctx context.Context,
+	fn func(ctx context.Context, done map[int64]applied) error,
+) error {
+	return m.transactor.WithAdvisoryLockMode(
+		ctx,
+		"migrate:"+m.table,
+		func(ctx context.Context) error {
+			if err := m.ensureTable(ctx); err != nil {
+				return err
+			}
+
+			done, err := m.applied(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, record := range done {
+				mig, ok := m.find(record.Version)
+				if ok && mig.Checksum != record.Checksum {
+					return errors.Wrapf(ErrChecksumMismatch, "%d_%s", mig.Version, mig.Name)
+				}
+			}
+
+			return fn(ctx, done)
+		},
+		transactor.LockSession,
+	)
+}
+
+// apply runs the script and records the result.
+// A script outside of a transaction is recorded after it succeeds,
+// so if recording fails, the script must be safe to run again.
+// It is not run in the transaction of ctx, which Postgres would reject
+// for statements like CREATE INDEX CONCURRENTLY.
+func (m *Migrator) apply(ctx context.Context, mig Migration, script Script, up bool) error {
+	run := func(ctx context.Context) error {
+		if _, err := m.transactor.ExtractTx(ctx).Exec(ctx, script.SQL); err != nil {
+			return errors.Wrap(err, "run script")
+		}
+
+		return m.record(ctx, mig, up)
+	}
+
+	if script.NoTransaction {
+		return m.transactor.WithTxPropagation(ctx, run, pgx.TxOptions{}, transactor.PropagationNever)
+	}
+
+	return m.transactor.WithTx(ctx, run)
+}
+
+func (m *Migrator) record(ctx context.Context, mig Migration, up bool) error {
+	var err error
+
+	if up {
+		_, err = m.transactor.ExtractTx(ctx).Exec(
+			ctx,
+			fmt.Sprintf("INSERT INTO %s (version, name, checksum) VALUES ($1, $2, $3)", m.table),
+			mig.Version,
+			mig.Name,
+			mig.Checksum,
+		)
+	} else {
+		_, err = m.transactor.ExtractTx(ctx).Exec(
+			ctx,
+			fmt.Sprintf("DELETE FROM %s WHERE version = $1", m.table),
+			mig.Version,
+		)
+	}
+
+	return errors.Wrap(err, "record version")
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.transactor.ExtractTx(ctx).Exec(ctx, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	version    bigint      PRIMARY KEY,
+	name       text        NOT NULL,
+	checksum   text        NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`, m.table))
+
+	return errors.Wrap(err, "create migrations table")
+}
+
+func (m *Migrator) tableExists(ctx context.Context) (bool, error) {
+	// the table is created on the primary
+	ctx = transactor.ForcePrimary(ctx)
+
+	var exists bool
+
+	err := m.transactor.ExtractTx(ctx).QueryRow(
+		ctx,
+		"SELECT to_regclass($1) IS NOT NULL",
+		m.table,
+	).Scan(&exists)
+
+	return exists, errors.Wrap(err, "find migrations table")
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]applied, error) {
+	// versions must be read from the primary, where they are written
+	ctx = transactor.ForcePrimary(ctx)
+
+	records, err := transactor.QueryAll[applied](
+		ctx,
+		m.transactor.ExtractTx(ctx),
+		fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s", m.table),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "read applied versions")
+	}
+
+	done := make(map[int64]applied, len(records))
+	for _, record := range records {
+		done[record.Version] = record
+	}
+
+	return done, nil
+}
+
+func (m *Migrator) known(version int64) bool {
+	_, ok := m.find(version)
+	return ok
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+
+	return Migration{}, false
+}
@@ -0,0 +1,290 @@
+package migrate_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/internal/pgtest"
+	"github.com/bogi-lyceya-44/common/pkg/migrate"
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/bogi-lyceya-44/common/pkg/transactor/transactortest"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		fsys     fstest.MapFS
+		expected []migrate.Migration
+		err      error
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"0002_index.up.sql":  file(migrate.NoTransactionMarker + "\nCREATE INDEX CONCURRENTLY i ON t (a)"),
+				"0001_init.up.sql":   file("CREATE TABLE t (a int)"),
+				"0001_init.down.sql": file("DROP TABLE t"),
+				"README.md":          file("ignored"),
+			},
+			expected: []migrate.Migration{
+				{
+					Version: 1,
+					Name:    "init",
+					Up:      migrate.Script{SQL: "CREATE TABLE t (a int)"},
+					Down:    &migrate.Script{SQL: "DROP TABLE t"},
+				},
+				{
+					Version: 2,
+					Name:    "index",
+					Up: migrate.Script{
+						SQL:           migrate.NoTransactionMarker + "\nCREATE INDEX CONCURRENTLY i ON t (a)",
+						NoTransaction: true,
+					},
+				},
+			},
+		},
+		{
+			name: "no up file",
+			fsys: fstest.MapFS{
+				"0001_init.down.sql": file("DROP TABLE t"),
+			},
+			err: migrate.ErrInvalidMigration,
+		},
+		{
+			name: "version used twice",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql":  file("CREATE TABLE t (a int)"),
+				"0001_other.up.sql": file("CREATE TABLE u (a int)"),
+			},
+			err: migrate.ErrInvalidMigration,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			m, err := migrate.New(transactor.New(transactortest.New()), tc.fsys)
+			if tc.err != nil {
+				require.ErrorIs(st, err, tc.err)
+				return
+			}
+
+			require.NoError(st, err)
+
+			migrations := m.Migrations()
+			for i := range migrations {
+				assert.NotEmpty(st, migrations[i].Checksum)
+				migrations[i].Checksum = ""
+			}
+
+			assert.Equal(st, tc.expected, migrations)
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"0001_init.up.sql":  file("CREATE TABLE t (a int)"),
+		"0002_users.up.sql": file("CREATE TABLE users (id int)"),
+		"0003_posts.up.sql": file("CREATE TABLE posts (id int)"),
+	}
+
+	appliedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	fake := transactortest.New()
+	tr := transactor.New(fake)
+
+	m, err := migrate.New(tr, fsys)
+	require.NoError(t, err)
+
+	fake.OnQuery = func(sql string, _ []any) (transactortest.Result, error) {
+		if strings.Contains(sql, "to_regclass") {
+			return transactortest.Result{Columns: []string{"exists"}, Rows: [][]any{{true}}}, nil
+		}
+
+		return transactortest.Result{
+			Columns: []string{"version", "name", "checksum", "applied_at"},
+			Rows: [][]any{
+				{int64(1), "init", m.Migrations()[0].Checksum, appliedAt},
+				{int64(2), "users", "changed", appliedAt},
+				{int64(4), "comments", "newer", appliedAt},
+			},
+		}, nil
+	}
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []migrate.Status{
+		{Version: 1, Name: "init", Applied: true, AppliedAt: appliedAt},
+		{Version: 2, Name: "users", Applied: true, AppliedAt: appliedAt, Changed: true},
+		{Version: 3, Name: "posts"},
+		{Version: 4, Name: "comments", Applied: true, AppliedAt: appliedAt, Missing: true},
+	}, statuses)
+}
+
+func TestStatusWithoutTable(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"0001_init.up.sql":  file("CREATE TABLE t (a int)"),
+		"0002_users.up.sql": file("CREATE TABLE users (id int)"),
+	}
+
+	fake := transactortest.New()
+	fake.OnQuery = func(string, []any) (transactortest.Result, error) {
+		return transactortest.Result{Columns: []string{"exists"}, Rows: [][]any{{false}}}, nil
+	}
+
+	m, err := migrate.New(transactor.New(fake), fsys)
+	require.NoError(t, err)
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []migrate.Status{
+		{Version: 1, Name: "init"},
+		{Version: 2, Name: "users"},
+	}, statuses)
+
+	// the table is not created
+	assert.Len(t, fake.SQL(), 1)
+}
+
+// newMigrator connects to the test database
+// and returns a migrator with a versions table unique for the test
+func newMigrator(t *testing.T, fsys fstest.MapFS) (*migrate.Migrator, *pgxpool.Pool) {
+	t.Helper()
+
+	pool := pgtest.Connect(t)
+	table := "migrations_" + strings.ToLower(t.Name())
+
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS "+table)
+	})
+
+	m, err := migrate.New(transactor.New(pool), fsys, migrate.WithTable(table))
+	require.NoError(t, err)
+
+	return m, pool
+}
+
+func applied(t *testing.T, m *migrate.Migrator) []int64 {
+	t.Helper()
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+
+	var versions []int64
+
+	for _, s := range statuses {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+
+	return versions
+}
+
+func TestUpAndDown(t *testing.T) {
+	t.Parallel()
+
+	prefix := "migrate_" + strings.ToLower(t.Name())
+
+	fsys := fstest.MapFS{
+		"0001_init.up.sql": file(fmt.Sprintf(
+			"CREATE TABLE %[1]s (a int); INSERT INTO %[1]s VALUES (1);",
+			prefix,
+		)),
+		"0001_init.down.sql": file("DROP TABLE " + prefix),
+		"0002_index.up.sql": file(fmt.Sprintf(
+			"%s\nCREATE INDEX CONCURRENTLY %[2]s_idx ON %[2]s (a)",
+			migrate.NoTransactionMarker,
+			prefix,
+		)),
+		"0002_index.down.sql": file(fmt.Sprintf(
+			"%s\nDROP INDEX CONCURRENTLY %s_idx",
+			migrate.NoTransactionMarker,
+			prefix,
+		)),
+	}
+
+	m, pool := newMigrator(t, fsys)
+
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS "+prefix)
+	})
+
+	require.NoError(t, m.UpTo(context.Background(), 1))
+	assert.Equal(t, []int64{1}, applied(t, m))
+
+	// concurrent runs are serialized by the lock
+	group, ctx := errgroup.WithContext(context.Background())
+	for range 3 {
+		group.Go(func() error {
+			return m.Up(ctx)
+		})
+	}
+
+	require.NoError(t, group.Wait())
+	assert.Equal(t, []int64{1, 2}, applied(t, m))
+
+	require.NoError(t, m.DownTo(context.Background(), 0))
+	assert.Empty(t, applied(t, m))
+}
+
+func TestChangedMigration(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"0001_init.up.sql": file("SELECT 1"),
+	}
+
+	m, pool := newMigrator(t, fsys)
+	require.NoError(t, m.Up(context.Background()))
+
+	fsys["0001_init.up.sql"] = file("SELECT 2")
+
+	changed, err := migrate.New(
+		transactor.New(pool),
+		fsys,
+		migrate.WithTable("migrations_"+strings.ToLower(t.Name())),
+	)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, changed.Up(context.Background()), migrate.ErrChecksumMismatch)
+}
+
+func TestNoTransactionScriptInTransaction(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"0001_index.up.sql": file(migrate.NoTransactionMarker + "\nSELECT 1"),
+	}
+
+	m, pool := newMigrator(t, fsys)
+	tr := transactor.New(pool)
+
+	err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+		return m.Up(ctx)
+	})
+	require.ErrorIs(t, err, transactor.ErrTransactionExists)
+
+	assert.Empty(t, applied(t, m))
+}
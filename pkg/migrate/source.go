@@ -0,0 +1,135 @@
+package migrate
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NoTransactionMarker on the first line of a file makes it run
+// outside of a transaction, e.g. for CREATE INDEX CONCURRENTLY.
+// Such a file is sent as a single query, and Postgres runs multiple statements
+// of a query in an implicit transaction, so it should hold one statement.
+const NoTransactionMarker = "-- migrate:no-transaction"
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Script is the SQL of one direction of a migration.
+type Script struct {
+	SQL           string
+	NoTransaction bool
+}
+
+// Migration is a versioned pair of scripts.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      Script
+	// Down is nil if the migration can not be rolled back.
+	Down *Script
+	// Checksum is the SHA-256 of the up script.
+	// The down script is not covered, so it can be changed after applying.
+	Checksum string
+}
+
+// load reads migrations from the root of fsys sorted by version.
+// Files not matching NNNN_name.up.sql or NNNN_name.down.sql are ignored.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "read migrations")
+	}
+
+	byVersion := make(map[int64]*Migration)
+	hasUp := make(map[int64]bool)
+
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidMigration, "version of %q", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %q", entry.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, errors.Wrapf(
+				ErrInvalidMigration,
+				"version %d is used by %q and %q",
+				version,
+				m.Name,
+				match[2],
+			)
+		}
+
+		script := parseScript(string(content))
+
+		if match[3] == "up" {
+			m.Up = script
+			m.Checksum = checksum(content)
+			hasUp[version] = true
+		} else {
+			m.Down = &script
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for version, m := range byVersion {
+		if !hasUp[version] {
+			return nil, errors.Wrapf(ErrInvalidMigration, "version %d has no up file", version)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sortMigrations(migrations)
+
+	return migrations, nil
+}
+
+func sortMigrations(migrations []Migration) {
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+}
+
+func sortStatuses(statuses []Status) {
+	slices.SortFunc(statuses, func(a, b Status) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+}
+
+func parseScript(content string) Script {
+	firstLine, _, _ := strings.Cut(content, "\n")
+
+	return Script{
+		SQL:           content,
+		NoTransaction: strings.TrimSpace(firstLine) == NoTransactionMarker,
+	}
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
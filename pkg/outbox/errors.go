@@ -0,0 +1,5 @@
+package outbox
+
+import "github.com/pkg/errors"
+
+var ErrAlreadyRunning = errors.New("relay is already running")
@@ -0,0 +1,153 @@
+// Package outbox implements the transactional outbox pattern:
+// messages are stored in the same transaction as the business data
+// and delivered to a broker by a Relay afterwards.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// DefaultTable is the name of the outbox table used by default.
+const DefaultTable = "outbox"
+
+// Schema returns the DDL of the outbox table.
+// The name is inserted as is, so it must come from trusted configuration.
+func Schema(table string) string {
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
+	id           bigserial   PRIMARY KEY,
+	topic        text        NOT NULL,
+	key          text,
+	payload      bytea       NOT NULL,
+	created_at   timestamptz NOT NULL DEFAULT now(),
+	attempts     int         NOT NULL DEFAULT 0,
+	locked_until timestamptz,
+	delivered_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS %[1]s_pending_idx
+	ON %[1]s (id) WHERE delivered_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS %[1]s_key_idx
+	ON %[1]s (key, id) WHERE delivered_at IS NULL AND key IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS %[1]s_delivered_idx
+	ON %[1]s (delivered_at) WHERE delivered_at IS NOT NULL;
+`, table)
+}
+
+// Message is a message stored in the outbox.
+type Message struct {
+	ID    int64
+	Topic string
+	// Key is the aggregate key.
+	// Messages with the same key are delivered one at a time
+	// in the order they were enqueued.
+	// Messages without a key are not ordered.
+	Key       string
+	Payload   []byte
+	CreatedAt time.Time
+	// Attempt is the number of the current delivery attempt starting from 1.
+	Attempt int
+}
+
+// EnqueueOption sets optional fields of an enqueued message.
+type EnqueueOption func(*Message)
+
+// WithKey sets the aggregate key of the message.
+func WithKey(key string) EnqueueOption {
+	return func(m *Message) {
+		m.Key = key
+	}
+}
+
+type Option func(*Outbox)
+
+// WithTable sets the name of the outbox table. Default is DefaultTable.
+func WithTable(table string) Option {
+	return func(o *Outbox) {
+		o.table = table
+	}
+}
+
+// Outbox stores messages in the transaction found in context.
+type Outbox struct {
+	transactor *transactor.Transactor
+	table      string
+}
+
+func New(tr *transactor.Transactor, opts ...Option) *Outbox {
+	o := &Outbox{
+		transactor: tr,
+		table:      DefaultTable,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Enqueue stores the message in the transaction found in context,
+// so it is delivered only if the transaction commits.
+// Returns transactor.ErrNoTransaction if there is no transaction,
+// since a message stored separately from the business data
+// is exactly the dual write the outbox is meant to prevent.
+//
+// A message with a key takes a transaction-scoped advisory lock on the key,
+// so transactions enqueuing the same key commit one after another
+// and ids of the key follow the commit order the relay relies on.
+// The lock is held until the transaction ends.
+func (o *Outbox) Enqueue(
+	ctx context.Context,
+	topic string,
+	payload []byte,
+	opts ...EnqueueOption,
+) error {
+	msg := Message{
+		Topic:   topic,
+		Payload: payload,
+	}
+
+	for _, opt := range opts {
+		opt(&msg)
+	}
+
+	return o.transactor.WithTxPropagation(
+		ctx,
+		func(ctx context.Context) error {
+			if msg.Key != "" {
+				_, err := o.transactor.ExtractTx(ctx).Exec(
+					ctx,
+					"SELECT pg_advisory_xact_lock($1)",
+					transactor.LockKey(o.table+":"+msg.Key),
+				)
+				if err != nil {
+					return errors.Wrap(err, "lock outbox key")
+				}
+			}
+
+			_, err := o.transactor.ExtractTx(ctx).Exec(
+				ctx,
+				fmt.Sprintf(
+					"INSERT INTO %s (topic, key, payload) VALUES ($1, NULLIF($2, ''), $3)",
+					o.table,
+				),
+				msg.Topic,
+				msg.Key,
+				msg.Payload,
+			)
+
+			return errors.Wrap(err, "insert outbox message")
+		},
+		pgx.TxOptions{},
+		transactor.PropagationMandatory,
+	)
+}
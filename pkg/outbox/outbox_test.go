@@ -0,0 +1,338 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/internal/pgtest"
+	"github.com/bogi-lyceya-44/common/pkg/outbox"
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/bogi-lyceya-44/common/pkg/transactor/transactortest"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newOutbox creates an outbox table unique for the test.
+func newOutbox(t *testing.T) (*outbox.Outbox, *transactor.Transactor, *pgxpool.Pool) {
+	t.Helper()
+
+	pool := pgtest.Connect(t)
+	table := "outbox_" + strings.ToLower(t.Name())
+
+	_, err := pool.Exec(
+		context.Background(),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s;", table)+outbox.Schema(table),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS "+table)
+	})
+
+	tr := transactor.New(pool)
+
+	return outbox.New(tr, outbox.WithTable(table)), tr, pool
+}
+
+// recorder is a publisher that remembers delivered payloads
+// and fails the first failures calls.
+type recorder struct {
+	mu        sync.Mutex
+	failures  int
+	delivered []string
+}
+
+func (r *recorder) Publish(_ context.Context, messages []outbox.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("broker is down")
+	}
+
+	for _, msg := range messages {
+		r.delivered = append(r.delivered, string(msg.Payload))
+	}
+
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.delivered)
+}
+
+func TestEnqueueRequiresTransaction(t *testing.T) {
+	t.Parallel()
+
+	o := outbox.New(transactor.New(nil))
+
+	err := o.Enqueue(context.Background(), "topic", []byte("payload"))
+	require.ErrorIs(t, err, transactor.ErrNoTransaction)
+}
+
+func TestEnqueueLocksKey(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		opts     []outbox.EnqueueOption
+		expected []string
+	}{
+		{
+			name:     "without key",
+			expected: []string{"INSERT"},
+		},
+		{
+			name:     "with key",
+			opts:     []outbox.EnqueueOption{outbox.WithKey("order-1")},
+			expected: []string{"SELECT pg_advisory_xact_lock", "INSERT"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			fake := transactortest.New()
+			tr := transactor.New(fake)
+			o := outbox.New(tr)
+
+			err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+				return o.Enqueue(ctx, "topic", []byte("payload"), tc.opts...)
+			})
+			require.NoError(st, err)
+
+			statements := fake.SQL()
+			require.Len(st, statements, len(tc.expected))
+
+			for i, prefix := range tc.expected {
+				assert.True(st, strings.HasPrefix(statements[i], prefix), statements[i])
+			}
+		})
+	}
+}
+
+func TestEnqueueSerializesKey(t *testing.T) {
+	t.Parallel()
+
+	o, tr, pool := newOutbox(t)
+
+	enqueued := make(chan struct{})
+	commit := make(chan struct{})
+	first := make(chan error, 1)
+
+	go func() {
+		first <- tr.WithTx(context.Background(), func(ctx context.Context) error {
+			if err := o.Enqueue(ctx, "topic", []byte("first"), outbox.WithKey("k")); err != nil {
+				return err
+			}
+
+			close(enqueued)
+			<-commit
+
+			return nil
+		})
+	}()
+
+	<-enqueued
+
+	second := make(chan error, 1)
+
+	go func() {
+		second <- tr.WithTx(context.Background(), func(ctx context.Context) error {
+			return o.Enqueue(ctx, "topic", []byte("second"), outbox.WithKey("k"))
+		})
+	}()
+
+	// the second producer waits for the first one to commit
+	select {
+	case err := <-second:
+		require.Failf(t, "second enqueue did not wait", "err: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(commit)
+	require.NoError(t, <-first)
+	require.NoError(t, <-second)
+
+	var payloads []string
+
+	rows, err := pool.Query(
+		context.Background(),
+		"SELECT payload FROM outbox_"+strings.ToLower(t.Name())+" ORDER BY id",
+	)
+	require.NoError(t, err)
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var payload []byte
+
+		require.NoError(t, rows.Scan(&payload))
+		payloads = append(payloads, string(payload))
+	}
+
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"first", "second"}, payloads)
+}
+
+func TestEnqueueRolledBack(t *testing.T) {
+	t.Parallel()
+
+	o, tr, pool := newOutbox(t)
+
+	errRollback := errors.New("rollback")
+
+	err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+		require.NoError(t, o.Enqueue(ctx, "topic", []byte("payload")))
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	var count int
+
+	err = pool.QueryRow(
+		context.Background(),
+		"SELECT count(*) FROM outbox_"+strings.ToLower(t.Name()),
+	).Scan(&count)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestRelayDeliversInKeyOrder(t *testing.T) {
+	t.Parallel()
+
+	o, tr, _ := newOutbox(t)
+
+	const (
+		keys     = 3
+		perKey   = 10
+		messages = keys * perKey
+	)
+
+	err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+		for i := range perKey {
+			for k := range keys {
+				payload := fmt.Sprintf("%d-%02d", k, i)
+				require.NoError(t, o.Enqueue(ctx, "topic", []byte(payload), outbox.WithKey(fmt.Sprint(k))))
+			}
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	publisher := &recorder{failures: 2}
+
+	relay := outbox.NewRelay(o, publisher, outbox.RelayConfig{
+		BatchSize:    4,
+		Workers:      3,
+		FlushTimeout: 10 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+		RetryDelay:   10 * time.Millisecond,
+	})
+
+	require.NoError(t, relay.Start(context.Background()))
+
+	require.Eventually(t, func() bool {
+		return publisher.count() >= messages
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, relay.Stop(context.Background()))
+
+	// nothing is delivered twice after a successful publish,
+	// and every key keeps its order
+	require.Len(t, publisher.delivered, messages)
+
+	last := make(map[string]string)
+
+	for _, payload := range publisher.delivered {
+		key := payload[:1]
+		assert.Greater(t, payload, last[key])
+		last[key] = payload
+	}
+}
+
+func TestRelayDrainsBusyKey(t *testing.T) {
+	t.Parallel()
+
+	o, tr, _ := newOutbox(t)
+
+	const messages = 50
+
+	err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+		for i := range messages {
+			payload := fmt.Sprintf("%02d", i)
+			require.NoError(t, o.Enqueue(ctx, "topic", []byte(payload), outbox.WithKey("busy")))
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	publisher := &recorder{}
+
+	// only deliveries wake up the poller
+	relay := outbox.NewRelay(o, publisher, outbox.RelayConfig{
+		FlushTimeout: time.Millisecond,
+		PollInterval: time.Hour,
+	})
+
+	require.NoError(t, relay.Start(context.Background()))
+
+	require.Eventually(t, func() bool {
+		return publisher.count() >= messages
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, relay.Stop(context.Background()))
+
+	require.Len(t, publisher.delivered, messages)
+	assert.IsIncreasing(t, publisher.delivered)
+}
+
+func TestRelayCleanup(t *testing.T) {
+	t.Parallel()
+
+	o, tr, pool := newOutbox(t)
+
+	err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+		return o.Enqueue(ctx, "topic", []byte("payload"))
+	})
+	require.NoError(t, err)
+
+	publisher := &recorder{}
+
+	relay := outbox.NewRelay(o, publisher, outbox.RelayConfig{
+		FlushTimeout:    10 * time.Millisecond,
+		PollInterval:    10 * time.Millisecond,
+		Retention:       time.Millisecond,
+		CleanupInterval: 10 * time.Millisecond,
+	})
+
+	require.NoError(t, relay.Start(context.Background()))
+
+	t.Cleanup(func() {
+		_ = relay.Stop(context.Background())
+	})
+
+	require.Eventually(t, func() bool {
+		var count int
+
+		err := pool.QueryRow(
+			context.Background(),
+			"SELECT count(*) FROM outbox_"+strings.ToLower(t.Name()),
+		).Scan(&count)
+
+		return err == nil && count == 0 && publisher.count() == 1
+	}, 10*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,420 @@
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/bogi-lyceya-44/common/pkg/worker"
+	"github.com/pkg/errors"
+)
+
+// Publisher delivers messages to a broker.
+// If it returns an error, the whole batch is delivered again later,
+// so it must tolerate duplicates.
+type Publisher interface {
+	Publish(ctx context.Context, messages []Message) error
+}
+
+// PublisherFunc is a function implementing Publisher.
+type PublisherFunc func(ctx context.Context, messages []Message) error
+
+func (f PublisherFunc) Publish(ctx context.Context, messages []Message) error {
+	return f(ctx, messages)
+}
+
+// RelayConfig configures a Relay. Zero fields are set to defaults.
+type RelayConfig struct {
+	// BatchSize is the maximum number of messages claimed by one poll
+	// and passed to one Publish call. Default is 100.
+	BatchSize int
+	// Workers is the number of concurrent Publish calls. Default is 1.
+	Workers int
+	// FlushTimeout is the longest time a claimed message waits
+	// for its batch to fill up. Default is 100ms.
+	FlushTimeout time.Duration
+	// PollInterval is the longest delay after a poll that found no messages.
+	// The next poll starts earlier when a batch is delivered,
+	// since it may unblock later messages of its keys. Default is 1s.
+	PollInterval time.Duration
+	// Lease is how long claimed messages are hidden from other relays.
+	// It must cover the time a message waits in the pool
+	// and the time of Publish, otherwise messages are delivered twice.
+	// Default is 30s.
+	Lease time.Duration
+	// RetryDelay is the delay before messages of a failed batch
+	// are delivered again. Default is 5s.
+	RetryDelay time.Duration
+	// Retention is how long delivered messages are kept.
+	// Zero keeps them forever.
+	Retention time.Duration
+	// CleanupInterval is the delay between deletions
+	// of messages older than Retention. Default is 1h.
+	CleanupInterval time.Duration
+	// OnError receives errors of polls, publishing and cleanup.
+	OnError func(error)
+	// Clock is used for poll and cleanup intervals. Default is clock.Real().
+	Clock clock.Clock
+}
+
+func (c *RelayConfig) setDefaults() {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+
+	if c.FlushTimeout <= 0 {
+		c.FlushTimeout = 100 * time.Millisecond
+	}
+
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+
+	if c.Lease <= 0 {
+		c.Lease = 30 * time.Second
+	}
+
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = 5 * time.Second
+	}
+
+	if c.CleanupInterval <= 0 {
+		c.CleanupInterval = time.Hour
+	}
+
+	if c.Clock == nil {
+		c.Clock = clock.Real()
+	}
+}
+
+// Relay delivers messages from the outbox to a Publisher.
+//
+// Messages are claimed with FOR UPDATE SKIP LOCKED and a lease,
+// so any number of relays may poll the same table.
+// A message is marked as delivered only after Publish succeeds,
+// which gives at-least-once delivery.
+// Only the oldest undelivered message of every aggregate key is claimed,
+// so messages with the same key are delivered in id order,
+// which is their commit order since Enqueue serializes producers of a key.
+// Delivering a batch wakes up the poller to claim the next messages
+// of its keys, so a busy key is not limited by PollInterval.
+type Relay struct {
+	outbox    *Outbox
+	publisher Publisher
+	config    RelayConfig
+	pool      *worker.WorkerPool[Message]
+	// delivered is signaled when a batch is marked as delivered
+	delivered chan struct{}
+
+	mu      *sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+
+	wg *sync.WaitGroup
+}
+
+func NewRelay(outbox *Outbox, publisher Publisher, config RelayConfig) *Relay {
+	config.setDefaults()
+
+	r := &Relay{
+		outbox:    outbox,
+		publisher: publisher,
+		config:    config,
+		delivered: make(chan struct{}, 1),
+		mu:        &sync.Mutex{},
+		wg:        &sync.WaitGroup{},
+	}
+
+	r.pool = worker.New(
+		config.BatchSize,
+		config.Workers,
+		config.BatchSize,
+		config.FlushTimeout,
+		r.publish,
+		worker.WithFlushMode[Message](worker.FlushOnMaxLatency),
+		worker.WithErrorHandler[Message](r.reportError),
+		worker.WithClock[Message](config.Clock),
+		worker.WithName[Message]("outbox-relay"),
+	)
+
+	return r
+}
+
+// Start starts polling the outbox.
+// Polling is stopped when ctx is done or Stop is called,
+// Stop must be called anyway to publish the claimed messages.
+func (r *Relay) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running {
+		return ErrAlreadyRunning
+	}
+
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.running = true
+
+	// the pool outlives ctx to publish the messages claimed so far,
+	// it is drained by Stop
+	r.pool.Start(context.WithoutCancel(ctx))
+
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+		r.poll(ctx)
+	}()
+
+	if r.config.Retention > 0 {
+		r.wg.Add(1)
+
+		go func() {
+			defer r.wg.Done()
+			r.cleanup(ctx)
+		}()
+	}
+
+	return nil
+}
+
+// Stop stops polling and waits until claimed messages are published
+// until ctx is done. Messages left unpublished are delivered
+// by any relay after their lease expires.
+func (r *Relay) Stop(ctx context.Context) error {
+	r.mu.Lock()
+
+	if r.running {
+		r.cancel()
+		r.running = false
+	}
+
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait for poller")
+	}
+
+	return errors.Wrap(r.pool.Drain(ctx), "drain publishers")
+}
+
+// poll claims messages until ctx is done.
+// Polls follow each other without a delay while they claim messages.
+func (r *Relay) poll(ctx context.Context) {
+	for {
+		claimed, err := r.claimAndSend(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.reportError(err)
+
+		if err == nil && claimed > 0 {
+			continue
+		}
+
+		if !r.waitDelivered(ctx) {
+			return
+		}
+	}
+}
+
+// waitDelivered waits for PollInterval or until a batch is delivered
+// and reports false if ctx is done first.
+func (r *Relay) waitDelivered(ctx context.Context) bool {
+	timer := r.config.Clock.NewTimer(r.config.PollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-r.delivered:
+		return true
+	case <-timer.C():
+		return true
+	}
+}
+
+func (r *Relay) claimAndSend(ctx context.Context) (int, error) {
+	messages, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range messages {
+		r.pool.Send(msg)
+	}
+
+	return len(messages), nil
+}
+
+// cleanup deletes delivered messages older than the retention until ctx is done.
+func (r *Relay) cleanup(ctx context.Context) {
+	for r.sleep(ctx, r.config.CleanupInterval) {
+		if err := r.deleteDelivered(ctx); err != nil && ctx.Err() == nil {
+			r.reportError(err)
+		}
+	}
+}
+
+// sleep waits for the delay and reports false if ctx is done first.
+func (r *Relay) sleep(ctx context.Context, delay time.Duration) bool {
+	timer := r.config.Clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
+
+// publish is the process function of the pool.
+func (r *Relay) publish(ctx context.Context, messages []Message) {
+	ids := make([]int64, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+
+	if err := r.publisher.Publish(ctx, messages); err != nil {
+		r.reportError(errors.Wrapf(err, "publish %d messages", len(messages)))
+		r.reportError(r.release(ctx, ids))
+
+		return
+	}
+
+	if err := r.markDelivered(ctx, ids); err != nil {
+		r.reportError(err)
+		return
+	}
+
+	select {
+	case r.delivered <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) reportError(err error) {
+	if err != nil && r.config.OnError != nil {
+		r.config.OnError(err)
+	}
+}
+
+func (r *Relay) claim(ctx context.Context) ([]Message, error) {
+	// the claim is a write, it must not be routed to a replica
+	ctx = transactor.ForcePrimary(ctx)
+
+	rows, err := r.outbox.transactor.ExtractTx(ctx).Query(
+		ctx,
+		fmt.Sprintf(`
+WITH claimed AS (
+	SELECT id FROM %[1]s m
+	WHERE delivered_at IS NULL
+		AND (locked_until IS NULL OR locked_until < now())
+		AND (key IS NULL OR NOT EXISTS (
+			SELECT 1 FROM %[1]s p
+			WHERE p.key = m.key AND p.delivered_at IS NULL AND p.id < m.id
+		))
+	ORDER BY id
+	LIMIT $1
+	FOR UPDATE OF m SKIP LOCKED
+)
+UPDATE %[1]s t
+SET locked_until = now() + make_interval(secs => $2), attempts = t.attempts + 1
+FROM claimed
+WHERE t.id = claimed.id
+RETURNING t.id, t.topic, coalesce(t.key, ''), t.payload, t.created_at, t.attempts`,
+			r.outbox.table,
+		),
+		r.config.BatchSize,
+		r.config.Lease.Seconds(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "claim outbox messages")
+	}
+
+	defer rows.Close()
+
+	var messages []Message
+
+	for rows.Next() {
+		var msg Message
+
+		err = rows.Scan(&msg.ID, &msg.Topic, &msg.Key, &msg.Payload, &msg.CreatedAt, &msg.Attempt)
+		if err != nil {
+			return nil, errors.Wrap(err, "scan outbox message")
+		}
+
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "claim outbox messages")
+	}
+
+	// RETURNING does not keep the order of the claimed rows
+	slices.SortFunc(messages, func(a, b Message) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return messages, nil
+}
+
+func (r *Relay) markDelivered(ctx context.Context, ids []int64) error {
+	_, err := r.outbox.transactor.ExtractTx(ctx).Exec(
+		ctx,
+		fmt.Sprintf(
+			"UPDATE %s SET delivered_at = now(), locked_until = NULL WHERE id = ANY($1)",
+			r.outbox.table,
+		),
+		ids,
+	)
+
+	return errors.Wrap(err, "mark outbox messages delivered")
+}
+
+// release makes messages of a failed batch available after the retry delay.
+func (r *Relay) release(ctx context.Context, ids []int64) error {
+	_, err := r.outbox.transactor.ExtractTx(ctx).Exec(
+		ctx,
+		fmt.Sprintf(
+			"UPDATE %s SET locked_until = now() + make_interval(secs => $2) WHERE id = ANY($1)",
+			r.outbox.table,
+		),
+		ids,
+		r.config.RetryDelay.Seconds(),
+	)
+
+	return errors.Wrap(err, "release outbox messages")
+}
+
+func (r *Relay) deleteDelivered(ctx context.Context) error {
+	_, err := r.outbox.transactor.ExtractTx(ctx).Exec(
+		ctx,
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE delivered_at < now() - make_interval(secs => $1)",
+			r.outbox.table,
+		),
+		r.config.Retention.Seconds(),
+	)
+
+	return errors.Wrap(err, "delete delivered outbox messages")
+}
@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"context"
+	stdErrors "errors"
+	"slices"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/worker"
+	pkgErrors "github.com/pkg/errors"
+)
+
+// Func transforms a batch of items of a stage
+// into items for the next stage.
+type Func[In, Out any] func(ctx context.Context, batch []In) ([]Out, error)
+
+// StageConfig configures batching and concurrency of a stage.
+type StageConfig struct {
+	Name         string
+	BufferSize   int
+	Workers      int
+	BatchSize    int
+	FlushTimeout time.Duration
+	// OnError receives errors returned by the stage function
+	// and errors of the underlying worker pool.
+	OnError worker.ErrorHandler
+}
+
+// Stage is a batching step of a pipeline backed by a worker pool.
+type Stage[In, Out any] struct {
+	config StageConfig
+	fn     Func[In, Out]
+	opts   []worker.Option[In]
+}
+
+// NewStage creates a stage.
+// Options are passed to the worker pool of the stage.
+func NewStage[In, Out any](
+	config StageConfig,
+	fn Func[In, Out],
+	opts ...worker.Option[In],
+) Stage[In, Out] {
+	return Stage[In, Out]{
+		config: config,
+		fn:     fn,
+		opts:   opts,
+	}
+}
+
+// runner is a stage with the item types erased.
+type runner interface {
+	Start(ctx context.Context)
+	Drain(ctx context.Context) error
+}
+
+// outlet passes outputs of a stage to the next one.
+// Outputs of the last stage are discarded.
+type outlet[T any] struct {
+	send func(T)
+}
+
+// Pipeline is a chain of stages that accepts In items
+// and produces Out items from the last stage.
+//
+// Every stage sends its outputs to the next stage,
+// blocking while the buffer of the next stage is full,
+// so backpressure propagates from the last stage to the first one.
+type Pipeline[In, Out any] struct {
+	send    func(In)
+	stages  []runner
+	outputs *outlet[Out]
+}
+
+// From creates a pipeline of a single stage.
+func From[In, Out any](stage Stage[In, Out]) *Pipeline[In, Out] {
+	pool, outputs := build(stage)
+
+	return &Pipeline[In, Out]{
+		send:    pool.Send,
+		stages:  []runner{pool},
+		outputs: outputs,
+	}
+}
+
+// Then appends a stage to the pipeline.
+// The passed pipeline must not be used after that.
+func Then[In, Mid, Out any](p *Pipeline[In, Mid], stage Stage[Mid, Out]) *Pipeline[In, Out] {
+	pool, outputs := build(stage)
+	p.outputs.send = pool.Send
+
+	return &Pipeline[In, Out]{
+		send:    p.send,
+		stages:  append(slices.Clip(p.stages), pool),
+		outputs: outputs,
+	}
+}
+
+// To sets the function that receives outputs of the last stage.
+// It is called from workers of the last stage and may block
+// to apply backpressure.
+func (p *Pipeline[In, Out]) To(sink func(Out)) *Pipeline[In, Out] {
+	p.outputs.send = sink
+	return p
+}
+
+// Start starts all stages, the last one first.
+func (p *Pipeline[In, Out]) Start(ctx context.Context) {
+	for _, stage := range slices.Backward(p.stages) {
+		stage.Start(ctx)
+	}
+}
+
+// Send sends an item to the first stage.
+// It blocks while the first stage is full.
+func (p *Pipeline[In, Out]) Send(item In) {
+	p.send(item)
+}
+
+// Stop drains the stages in order from the first to the last:
+// a stage is stopped only after all its items are processed
+// and passed to the next stage.
+// Items must not be sent while the pipeline is stopping.
+func (p *Pipeline[In, Out]) Stop(ctx context.Context) error {
+	var errs []error
+
+	for _, stage := range p.stages {
+		errs = append(errs, stage.Drain(ctx))
+	}
+
+	return stdErrors.Join(errs...)
+}
+
+func build[In, Out any](stage Stage[In, Out]) (*worker.WorkerPool[In], *outlet[Out]) {
+	cfg := stage.config
+	outputs := &outlet[Out]{}
+
+	process := func(ctx context.Context, batch []In) {
+		results, err := stage.fn(ctx, batch)
+		if err != nil {
+			if cfg.OnError != nil {
+				cfg.OnError(pkgErrors.Wrapf(err, "stage %q", cfg.Name))
+			}
+
+			return
+		}
+
+		if outputs.send == nil {
+			return
+		}
+
+		for _, result := range results {
+			outputs.send(result)
+		}
+	}
+
+	opts := []worker.Option[In]{worker.WithName[In](cfg.Name)}
+
+	if cfg.OnError != nil {
+		opts = append(opts, worker.WithErrorHandler[In](cfg.OnError))
+	}
+
+	pool := worker.New(
+		cfg.BufferSize,
+		cfg.Workers,
+		cfg.BatchSize,
+		cfg.FlushTimeout,
+		process,
+		append(opts, stage.opts...)...,
+	)
+
+	return pool, outputs
+}
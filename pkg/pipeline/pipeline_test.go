@@ -0,0 +1,84 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopDrainsAllStages(t *testing.T) {
+	t.Parallel()
+
+	config := pipeline.StageConfig{
+		BufferSize: 4,
+		Workers:    2,
+		BatchSize:  3,
+		// the timeout is long enough for partial batches
+		// to be flushed only by Stop
+		FlushTimeout: time.Hour,
+	}
+
+	parse := pipeline.NewStage(
+		config,
+		func(_ context.Context, batch []string) ([]int, error) {
+			result := make([]int, 0, len(batch))
+
+			for _, s := range batch {
+				x, err := strconv.Atoi(s)
+				if err != nil {
+					return nil, err
+				}
+
+				result = append(result, x)
+			}
+
+			return result, nil
+		},
+	)
+
+	double := pipeline.NewStage(
+		config,
+		func(_ context.Context, batch []int) ([]int, error) {
+			result := make([]int, 0, len(batch))
+
+			for _, x := range batch {
+				result = append(result, x*2)
+			}
+
+			return result, nil
+		},
+	)
+
+	var (
+		mu  sync.Mutex
+		got []int
+	)
+
+	p := pipeline.Then(pipeline.From(parse), double).To(func(x int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		got = append(got, x)
+	})
+
+	p.Start(context.Background())
+
+	want := make([]int, 0, 100)
+
+	for i := range 100 {
+		p.Send(strconv.Itoa(i))
+		want = append(want, i*2)
+	}
+
+	require.NoError(t, p.Stop(context.Background()))
+
+	slices.Sort(got)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+)
+
+// TokenBucket is a token bucket rate limiter safe for concurrent use.
+// The bucket is refilled at rate tokens per second up to burst tokens.
+//
+// Waiters reserve tokens in the order they arrive: a reservation
+// may drive the bucket into debt, and the next waiters wait
+// until the debt is paid, so a request larger than burst is allowed
+// and simply waits longer.
+type TokenBucket struct {
+	mu    *sync.Mutex
+	clock clock.Clock
+
+	rate  float64
+	burst float64
+
+	tokens float64
+	last   time.Time
+}
+
+type Option func(*TokenBucket)
+
+// WithClock sets the clock of the bucket. Default is clock.Real().
+func WithClock(c clock.Clock) Option {
+	return func(b *TokenBucket) {
+		b.clock = c
+	}
+}
+
+// NewTokenBucket creates a full bucket.
+// It panics if rate or burst is not positive.
+func NewTokenBucket(rate float64, burst int, opts ...Option) *TokenBucket {
+	if rate <= 0 || burst <= 0 {
+		// fail fast
+		panic("rate and burst must be positive")
+	}
+
+	b := &TokenBucket{
+		mu:     &sync.Mutex{},
+		clock:  clock.Real(),
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.last = b.clock.Now()
+
+	return b
+}
+
+// Allow takes n tokens if they are available right now.
+func (b *TokenBucket) Allow(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(b.clock.Now())
+
+	if b.tokens < float64(n) {
+		return false
+	}
+
+	b.tokens -= float64(n)
+
+	return true
+}
+
+// Wait blocks until n tokens are available and takes them.
+// If ctx is done first, the reserved tokens are returned
+// to the bucket and ctx.Err() is returned.
+func (b *TokenBucket) Wait(ctx context.Context, n int) error {
+	delay := b.reserve(n)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := b.clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		b.cancel(n)
+		return ctx.Err()
+	}
+}
+
+// reserve takes n tokens and returns how long to wait
+// until the bucket is out of debt.
+func (b *TokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(b.clock.Now())
+	b.tokens -= float64(n)
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *TokenBucket) cancel(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(b.clock.Now())
+	b.tokens = min(b.tokens+float64(n), b.burst)
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	if elapsed <= 0 {
+		return
+	}
+
+	b.tokens = min(b.tokens+elapsed.Seconds()*b.rate, b.burst)
+	b.last = now
+}
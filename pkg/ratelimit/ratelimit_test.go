@@ -0,0 +1,69 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllow(t *testing.T) {
+	t.Parallel()
+
+	// the rate is low enough for the bucket not to refill during the test
+	bucket := ratelimit.NewTokenBucket(0.001, 3)
+
+	assert.True(t, bucket.Allow(2))
+	assert.True(t, bucket.Allow(1))
+	assert.False(t, bucket.Allow(1))
+}
+
+func TestWaitCanceled(t *testing.T) {
+	t.Parallel()
+
+	bucket := ratelimit.NewTokenBucket(0.001, 2)
+
+	require.NoError(t, bucket.Wait(context.Background(), 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the bucket has one token, so waiting for two blocks until canceled
+	require.ErrorIs(t, bucket.Wait(ctx, 2), context.Canceled)
+
+	// the canceled reservation is returned to the bucket
+	assert.True(t, bucket.Allow(1))
+	assert.False(t, bucket.Allow(1))
+}
+
+func TestWaitRefill(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	bucket := ratelimit.NewTokenBucket(10, 1, ratelimit.WithClock(clk))
+
+	require.NoError(t, bucket.Wait(context.Background(), 1))
+
+	done := make(chan error)
+
+	go func() {
+		// a request larger than burst waits for the whole debt
+		done <- bucket.Wait(context.Background(), 3)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(299 * time.Millisecond)
+
+	select {
+	case <-done:
+		require.FailNow(t, "wait returned before tokens were refilled")
+	default:
+	}
+
+	clk.Advance(time.Millisecond)
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,232 @@
+package scheduler
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxCronLookahead limits the search of the next activation,
+// so impossible dates like February 30 don't loop forever.
+const maxCronLookahead = 5 * 366 * 24 * time.Hour
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{
+		name: "month",
+		min:  1,
+		max:  12,
+		names: map[string]int{
+			"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+			"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+		},
+	}
+	// 7 is Sunday as well as 0
+	dowField = cronField{
+		name: "day of week",
+		min:  0,
+		max:  7,
+		names: map[string]int{
+			"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+		},
+	}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cron is a parsed cron expression.
+// Every field is a bit set of allowed values.
+type cron struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// if both day fields are restricted,
+	// a day matches when either of them matches
+	domRestricted bool
+	dowRestricted bool
+}
+
+// Cron parses a standard 5-field cron expression:
+// minute, hour, day of month, month and day of week.
+// Fields support "*", values, ranges "a-b", steps "*/n" and "a-b/n",
+// lists "a,b" and three-letter month and weekday names.
+// Macros @yearly, @monthly, @weekly, @daily and @hourly are supported too.
+// Activation times are computed in the location of the passed time.
+func Cron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, errors.Wrapf(ErrInvalidCron, "%q: expected 5 fields, got %d", expr, len(parts))
+	}
+
+	var (
+		c   cron
+		err error
+	)
+
+	fields := []struct {
+		field cronField
+		bits  *uint64
+	}{
+		{minuteField, &c.minute},
+		{hourField, &c.hour},
+		{domField, &c.dom},
+		{monthField, &c.month},
+		{dowField, &c.dow},
+	}
+
+	for i, f := range fields {
+		*f.bits, err = parseCronField(parts[i], f.field)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%q", expr)
+		}
+	}
+
+	// Sunday may be written as 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domRestricted = parts[2] != "*" && !strings.HasPrefix(parts[2], "*/")
+	c.dowRestricted = parts[4] != "*" && !strings.HasPrefix(parts[4], "*/")
+
+	return c, nil
+}
+
+// MustCron is like Cron but panics if the expression is invalid.
+func MustCron(expr string) Schedule {
+	schedule, err := Cron(expr)
+	if err != nil {
+		panic(err)
+	}
+
+	return schedule
+}
+
+func parseCronField(value string, field cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(value, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+
+		if hasStep {
+			var err error
+
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, errors.Wrapf(ErrInvalidCron, "%s: invalid step %q", field.name, stepPart)
+			}
+		}
+
+		low, high := field.min, field.max
+
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+
+			low, err = parseCronValue(lowPart, field)
+			if err != nil {
+				return 0, err
+			}
+
+			high = low
+
+			switch {
+			case isRange:
+				high, err = parseCronValue(highPart, field)
+				if err != nil {
+					return 0, err
+				}
+			case hasStep:
+				// "a/n" means from a to the end with step n
+				high = field.max
+			}
+
+			if low > high {
+				return 0, errors.Wrapf(ErrInvalidCron, "%s: invalid range %q", field.name, rangePart)
+			}
+		}
+
+		for x := low; x <= high; x += step {
+			bits |= 1 << x
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(value string, field cronField) (int, error) {
+	if x, ok := field.names[strings.ToLower(value)]; ok {
+		return x, nil
+	}
+
+	x, err := strconv.Atoi(value)
+	if err != nil || x < field.min || x > field.max {
+		return 0, errors.Wrapf(ErrInvalidCron, "%s: invalid value %q", field.name, value)
+	}
+
+	return x, nil
+}
+
+func (c cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxCronLookahead)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+
+	return dom && dow
+}
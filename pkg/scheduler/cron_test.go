@@ -0,0 +1,100 @@
+package scheduler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/scheduler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronNext(t *testing.T) {
+	t.Parallel()
+
+	// Wednesday
+	after := time.Date(2025, time.January, 15, 10, 30, 45, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		expr string
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			want: time.Date(2025, time.January, 15, 10, 31, 0, 0, time.UTC),
+		},
+		{
+			name: "step",
+			expr: "*/15 * * * *",
+			want: time.Date(2025, time.January, 15, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "nightly",
+			expr: "0 3 * * *",
+			want: time.Date(2025, time.January, 16, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "range and list",
+			expr: "0 9-17/4 * * mon,fri",
+			want: time.Date(2025, time.January, 17, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week",
+			expr: "0 0 20 * sun",
+			want: time.Date(2025, time.January, 19, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 0 * * 7",
+			want: time.Date(2025, time.January, 19, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "month name",
+			expr: "0 0 1 mar *",
+			want: time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			want: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "macro",
+			expr: "@monthly",
+			want: time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "impossible date",
+			expr: "0 0 30 2 *",
+			want: time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(st *testing.T) {
+			st.Parallel()
+
+			schedule, err := scheduler.Cron(tt.expr)
+			require.NoError(st, err)
+
+			assert.Equal(st, tt.want, schedule.Next(after))
+		})
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, expr := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 5-1 * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+	} {
+		_, err := scheduler.Cron(expr)
+		assert.ErrorIs(t, err, scheduler.ErrInvalidCron, expr)
+	}
+}
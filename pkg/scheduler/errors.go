@@ -0,0 +1,10 @@
+package scheduler
+
+import "github.com/pkg/errors"
+
+var (
+	ErrInvalidCron    = errors.New("invalid cron expression")
+	ErrInvalidJob     = errors.New("invalid job")
+	ErrDuplicateJob   = errors.New("job already exists")
+	ErrAlreadyRunning = errors.New("scheduler is already running")
+)
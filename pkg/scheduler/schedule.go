@@ -0,0 +1,27 @@
+package scheduler
+
+import "time"
+
+// Schedule returns the next activation time after the given one.
+// A zero time means there are no more activations.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+type interval time.Duration
+
+// Every returns a schedule that is activated
+// every d after the previous activation.
+// It panics if d is not positive.
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		// fail fast
+		panic("interval <= 0")
+	}
+
+	return interval(d)
+}
+
+func (i interval) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(i))
+}
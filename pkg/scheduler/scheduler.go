@@ -0,0 +1,278 @@
+package scheduler
+
+import (
+	"context"
+	"math/rand/v2"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+// OverlapPolicy defines what happens when a job is due
+// while its previous run is still in progress.
+type OverlapPolicy int
+
+const (
+	// SkipIfRunning skips the activation.
+	SkipIfRunning OverlapPolicy = iota
+	// QueueIfRunning runs the job right after the previous run finishes.
+	// At most one run is queued, further activations are skipped.
+	QueueIfRunning
+)
+
+// Job is a recurring job.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	// Jitter is the upper bound of a random delay
+	// added to every activation.
+	Jitter  time.Duration
+	Overlap OverlapPolicy
+	// Timeout is the deadline of a single run. Zero means no deadline.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// ErrorHandler receives errors returned by jobs and recovered panics.
+type ErrorHandler func(job string, err error)
+
+type Option func(*Scheduler)
+
+// WithErrorHandler sets the handler of job errors.
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(s *Scheduler) {
+		s.onError = handler
+	}
+}
+
+// WithClock sets the clock of the scheduler. Default is clock.Real().
+func WithClock(c clock.Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = c
+	}
+}
+
+// WithLocation sets the location in which schedules are computed.
+// Default is time.Local.
+func WithLocation(location *time.Location) Option {
+	return func(s *Scheduler) {
+		s.location = location
+	}
+}
+
+// Scheduler runs recurring jobs.
+type Scheduler struct {
+	onError  ErrorHandler
+	location *time.Location
+	clock    clock.Clock
+
+	mu      *sync.Mutex
+	jobs    map[string]*scheduledJob
+	running bool
+	jobCtx  context.Context
+	cancel  context.CancelFunc
+
+	wg *sync.WaitGroup
+}
+
+type scheduledJob struct {
+	Job
+
+	// triggers holds at most one activation waiting for the runner
+	triggers chan struct{}
+	busy     atomic.Bool
+}
+
+func New(opts ...Option) *Scheduler {
+	s := &Scheduler{
+		location: time.Local,
+		clock:    clock.Real(),
+		mu:       &sync.Mutex{},
+		jobs:     make(map[string]*scheduledJob),
+		wg:       &sync.WaitGroup{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Add adds a job. Jobs added to a running scheduler start right away.
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return errors.Wrap(ErrInvalidJob, "name, schedule and run are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.Name]; ok {
+		return errors.Wrapf(ErrDuplicateJob, "%q", job.Name)
+	}
+
+	j := &scheduledJob{
+		Job:      job,
+		triggers: make(chan struct{}, 1),
+	}
+
+	s.jobs[job.Name] = j
+
+	if s.running {
+		s.launch(j)
+	}
+
+	return nil
+}
+
+// Start starts scheduling jobs.
+// Jobs are stopped when ctx is done or Stop is called.
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return ErrAlreadyRunning
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.running = true
+
+	// the context is kept to launch jobs added later
+	s.jobCtx = ctx
+
+	for _, j := range s.jobs {
+		s.launch(j)
+	}
+
+	return nil
+}
+
+// Stop stops scheduling, cancels contexts of running jobs
+// and waits for them to return until ctx is done.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+
+	if s.running {
+		s.cancel()
+		s.running = false
+	}
+
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait for running jobs")
+	}
+}
+
+// launch must be called with s.mu held.
+func (s *Scheduler) launch(j *scheduledJob) {
+	ctx := s.jobCtx
+
+	s.wg.Add(2)
+
+	go func() {
+		defer s.wg.Done()
+		s.schedule(ctx, j)
+	}()
+
+	go func() {
+		defer s.wg.Done()
+		s.runTriggered(ctx, j)
+	}()
+}
+
+// schedule triggers the job at its activation times.
+func (s *Scheduler) schedule(ctx context.Context, j *scheduledJob) {
+	next := j.Schedule.Next(s.clock.Now().In(s.location))
+
+	for !next.IsZero() {
+		delay := next.Sub(s.clock.Now())
+
+		if j.Jitter > 0 {
+			delay += rand.N(j.Jitter)
+		}
+
+		timer := s.clock.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		s.trigger(j)
+
+		// activations missed while the process was suspended
+		// or the machine was asleep are skipped
+		next = j.Schedule.Next(next)
+		if now := s.clock.Now(); !next.IsZero() && next.Before(now) {
+			next = j.Schedule.Next(now.In(s.location))
+		}
+	}
+}
+
+func (s *Scheduler) trigger(j *scheduledJob) {
+	if j.Overlap == SkipIfRunning && j.busy.Load() {
+		return
+	}
+
+	// the channel holds at most one activation,
+	// further ones are skipped until the runner takes it
+	select {
+	case j.triggers <- struct{}{}:
+	default:
+	}
+}
+
+// runTriggered runs the job on every trigger, one run at a time.
+func (s *Scheduler) runTriggered(ctx context.Context, j *scheduledJob) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-j.triggers:
+		}
+
+		j.busy.Store(true)
+		err := s.run(ctx, j)
+		j.busy.Store(false)
+
+		if err != nil && s.onError != nil {
+			s.onError(j.Name, err)
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, j *scheduledJob) (err error) {
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("job panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return j.Run(ctx)
+}
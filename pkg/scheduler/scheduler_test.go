@@ -0,0 +1,130 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/scheduler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const waitTimeout = 5 * time.Second
+
+func TestOverlapPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		overlap scheduler.OverlapPolicy
+		want    int
+	}{
+		{
+			name:    "skip if running",
+			overlap: scheduler.SkipIfRunning,
+			want:    1,
+		},
+		{
+			name:    "queue if running",
+			overlap: scheduler.QueueIfRunning,
+			want:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(st *testing.T) {
+			st.Parallel()
+
+			clk := clock.NewFake(time.Now())
+			started := make(chan struct{}, 10)
+			release := make(chan struct{})
+
+			s := scheduler.New(scheduler.WithClock(clk))
+
+			require.NoError(st, s.Add(scheduler.Job{
+				Name:     "job",
+				Schedule: scheduler.Every(time.Minute),
+				Overlap:  tt.overlap,
+				Run: func(ctx context.Context) error {
+					started <- struct{}{}
+					<-release
+
+					return nil
+				},
+			}))
+
+			require.NoError(st, s.Start(context.Background()))
+
+			clk.BlockUntil(1)
+			clk.Advance(time.Minute)
+
+			// the first run is in progress while the job is due twice more
+			<-started
+
+			for range 2 {
+				clk.BlockUntil(1)
+				clk.Advance(time.Minute)
+			}
+
+			// the next timer is armed after the job is triggered
+			clk.BlockUntil(1)
+			close(release)
+
+			for range tt.want - 1 {
+				select {
+				case <-started:
+				case <-time.After(waitTimeout):
+					require.FailNow(st, "queued run has not started")
+				}
+			}
+
+			require.NoError(st, s.Stop(context.Background()))
+
+			assert.Empty(st, started)
+		})
+	}
+}
+
+func TestJobErrors(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	errs := make(chan error, 1)
+
+	s := scheduler.New(
+		scheduler.WithClock(clk),
+		scheduler.WithErrorHandler(func(job string, err error) {
+			errs <- err
+		}),
+	)
+
+	require.NoError(t, s.Add(scheduler.Job{
+		Name:     "job",
+		Schedule: scheduler.Every(time.Second),
+		Run: func(ctx context.Context) error {
+			panic("boom")
+		},
+	}))
+
+	require.ErrorIs(
+		t,
+		s.Add(scheduler.Job{Name: "job", Schedule: scheduler.Every(time.Second), Run: func(context.Context) error { return nil }}),
+		scheduler.ErrDuplicateJob,
+	)
+
+	require.NoError(t, s.Start(context.Background()))
+
+	defer s.Stop(context.Background())
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "boom")
+	case <-time.After(waitTimeout):
+		require.FailNow(t, "error was not reported")
+	}
+}
@@ -0,0 +1,270 @@
+package transactor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/jackc/pgx/v5/pgxpool"
+	pkgErrors "github.com/pkg/errors"
+)
+
+// Change of leadership reported by Elector
+type LeadershipEvent int
+
+const (
+	LeadershipGained LeadershipEvent = iota + 1
+	LeadershipLost
+)
+
+// Configuration of Elector, zero fields are set to defaults
+type ElectorConfig struct {
+	// Delay between attempts to become the leader
+	// Default is 5s
+	RetryInterval time.Duration
+	// Delay between checks of the connection holding the lock
+	// If the connection is broken, the lock is lost with it,
+	// so another instance may become the leader before the check notices
+	// Default is 1s
+	CheckInterval time.Duration
+	// Receives errors of connections and queries
+	OnError func(error)
+	// Clock for the delays above
+	// Default is the clock of the transactor
+	Clock clock.Clock
+}
+
+func (c *ElectorConfig) setDefaults() {
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = 5 * time.Second
+	}
+
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = time.Second
+	}
+}
+
+// Leader election with a session-scoped advisory lock
+// held on a dedicated connection of the primary
+type Elector struct {
+	db     Acquirer
+	key    string
+	config ElectorConfig
+
+	events chan LeadershipEvent
+	leader atomic.Bool
+
+	mu      *sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+
+	wg *sync.WaitGroup
+}
+
+// Create an elector competing for the lock with the given name
+// The database must implement Acquirer, otherwise Start fails
+func (t Transactor) NewElector(key string, config ElectorConfig) *Elector {
+	config.setDefaults()
+
+	if config.Clock == nil {
+		config.Clock = t.clock
+	}
+
+	// nil if the database can not give out dedicated connections
+	acquirer, _ := t.db.(Acquirer)
+
+	return &Elector{
+		db:     acquirer,
+		key:    key,
+		config: config,
+		events: make(chan LeadershipEvent, 1),
+		mu:     &sync.Mutex{},
+		wg:     &sync.WaitGroup{},
+	}
+}
+
+// Events of gained and lost leadership
+// Only the latest unread event is kept, so a slow reader misses
+// intermediate changes but never blocks the elector
+// Use IsLeader for the current state
+func (e *Elector) Events() <-chan LeadershipEvent {
+	return e.events
+}
+
+// Whether the elector holds the lock
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Start competing for leadership until ctx is done or Stop is called
+func (e *Elector) Start(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.running {
+		return ErrAlreadyRunning
+	}
+
+	if e.db == nil {
+		return ErrSessionLocksUnsupported
+	}
+
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.running = true
+
+	e.wg.Add(1)
+
+	go func() {
+		defer e.wg.Done()
+		e.run(ctx)
+	}()
+
+	return nil
+}
+
+// Stop competing, release the lock and wait until ctx is done
+func (e *Elector) Stop(ctx context.Context) error {
+	e.mu.Lock()
+
+	if e.running {
+		e.cancel()
+		e.running = false
+	}
+
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return pkgErrors.Wrap(ctx.Err(), "wait for elector")
+	}
+}
+
+// Trying to take the lock until ctx is done
+func (e *Elector) run(ctx context.Context) {
+	for {
+		conn, err := e.acquire(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			e.reportError(err)
+		}
+
+		if conn != nil {
+			e.lead(ctx, conn)
+		}
+
+		if !sleep(ctx, e.config.Clock, e.config.RetryInterval) {
+			return
+		}
+	}
+}
+
+// Taking a connection holding the lock
+// Returns nil if the lock is held by someone else
+func (e *Elector) acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	conn, err := e.db.Acquire(ctx)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "acquire connection")
+	}
+
+	var acquired bool
+
+	err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", LockKey(e.key)).Scan(&acquired)
+	if err != nil {
+		discard(conn)
+		return nil, pkgErrors.Wrapf(err, "try lock %q", e.key)
+	}
+
+	if !acquired {
+		conn.Release()
+		return nil, nil
+	}
+
+	return conn, nil
+}
+
+// Holding the lock until the connection breaks or ctx is done
+func (e *Elector) lead(ctx context.Context, conn *pgxpool.Conn) {
+	e.setLeader(true)
+	defer e.setLeader(false)
+
+	for sleep(ctx, e.config.Clock, e.config.CheckInterval) {
+		if err := conn.Ping(ctx); err != nil {
+			if ctx.Err() == nil {
+				e.reportError(pkgErrors.Wrapf(err, "lost lock %q", e.key))
+			}
+
+			// the lock is gone with the session
+			discard(conn)
+
+			return
+		}
+	}
+
+	// shutdown: the connection goes back to the pool, so the lock must go
+	_, err := conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", LockKey(e.key))
+	if err != nil {
+		e.reportError(pkgErrors.Wrapf(err, "unlock %q", e.key))
+		discard(conn)
+
+		return
+	}
+
+	conn.Release()
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.leader.Store(leader)
+
+	event := LeadershipLost
+	if leader {
+		event = LeadershipGained
+	}
+
+	// replacing the unread event, setLeader is the only sender
+	select {
+	case <-e.events:
+	default:
+	}
+
+	e.events <- event
+}
+
+func (e *Elector) reportError(err error) {
+	if e.config.OnError != nil {
+		e.config.OnError(err)
+	}
+}
+
+// Closing the connection before release, so the pool destroys it
+// together with the session and its locks
+func discard(conn *pgxpool.Conn) {
+	_ = conn.Conn().Close(context.Background())
+	conn.Release()
+}
+
+// Waiting for the delay, reports false if ctx is done first
+func sleep(ctx context.Context, c clock.Clock, delay time.Duration) bool {
+	timer := c.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
@@ -0,0 +1,26 @@
+package transactor
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoTransaction           = errors.New("no transaction in context")
+	ErrNotFound                = errors.New("not found")
+	ErrTransactionExists       = errors.New("transaction already exists in context")
+	ErrLockNotAcquired         = errors.New("advisory lock is held by someone else")
+	ErrAlreadyRunning          = errors.New("elector is already running")
+	ErrSessionLocksUnsupported = errors.New("database does not give out dedicated connections")
+)
+
+// Returned instead of re-panicking when WithPanicAsError is set
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("tx function panicked: %v\n%s", e.Value, e.Stack)
+}
@@ -0,0 +1,79 @@
+package transactor
+
+import (
+	"context"
+	"slices"
+)
+
+// Callbacks of the outermost transaction,
+// shared by all of its savepoints
+type txHooks struct {
+	afterCommit   []func(context.Context)
+	afterRollback []func(context.Context)
+}
+
+// Register fn to run after the outermost transaction in context commits
+// Runs fn immediately if there is no transaction in context
+// If fn is registered in a savepoint that is rolled back, it is discarded
+func AfterCommit(ctx context.Context, fn func(context.Context)) {
+	state, ok := ctx.Value(txInjector{}).(*txState)
+	if !ok {
+		fn(ctx)
+		return
+	}
+
+	state.hooks.afterCommit = append(state.hooks.afterCommit, fn)
+}
+
+// Register fn to run after the outermost transaction in context rolls back
+// Runs fn immediately if there is no transaction in context, like AfterCommit
+// If fn is registered in a savepoint, it also runs right after the savepoint is rolled back
+func AfterRollback(ctx context.Context, fn func(context.Context)) {
+	state, ok := ctx.Value(txInjector{}).(*txState)
+	if !ok {
+		fn(ctx)
+		return
+	}
+
+	state.hooks.afterRollback = append(state.hooks.afterRollback, fn)
+}
+
+// Position of the hooks registered so far
+type hooksMark struct {
+	commit   int
+	rollback int
+}
+
+func (h *txHooks) mark() hooksMark {
+	return hooksMark{
+		commit:   len(h.afterCommit),
+		rollback: len(h.afterRollback),
+	}
+}
+
+// Running the hooks registered in a rolled back savepoint
+// and forgetting them, since the outermost transaction may still commit
+func (h *txHooks) rollbackTo(ctx context.Context, m hooksMark) {
+	// hooks may register new ones, which would overwrite the truncated tail
+	rolledBack := slices.Clone(h.afterRollback[m.rollback:])
+
+	h.afterCommit = h.afterCommit[:m.commit]
+	h.afterRollback = h.afterRollback[:m.rollback]
+
+	runHooks(ctx, rolledBack)
+}
+
+// Running the hooks of the finished outermost transaction in registration order
+func (h *txHooks) finish(ctx context.Context, committed bool) {
+	if committed {
+		runHooks(ctx, h.afterCommit)
+	} else {
+		runHooks(ctx, h.afterRollback)
+	}
+}
+
+func runHooks(ctx context.Context, hooks []func(context.Context)) {
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+}
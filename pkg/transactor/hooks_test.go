@@ -0,0 +1,191 @@
+package transactor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/bogi-lyceya-44/common/pkg/transactor/transactortest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooksWithoutTransaction(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+
+	transactor.AfterCommit(context.Background(), func(context.Context) {
+		calls = append(calls, "commit")
+	})
+	transactor.AfterRollback(context.Background(), func(context.Context) {
+		calls = append(calls, "rollback")
+	})
+
+	assert.Equal(t, []string{"commit", "rollback"}, calls)
+}
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+
+	tr := transactor.New(transactortest.New())
+
+	errFn := errors.New("fn")
+
+	testCases := []struct {
+		name     string
+		fn       func(ctx context.Context, calls *[]string) error
+		expected []string
+	}{
+		{
+			name: "commit in registration order",
+			fn: func(ctx context.Context, calls *[]string) error {
+				register(ctx, calls, "first")
+				register(ctx, calls, "second")
+
+				return nil
+			},
+			expected: []string{"commit first", "commit second"},
+		},
+		{
+			name: "rollback",
+			fn: func(ctx context.Context, calls *[]string) error {
+				register(ctx, calls, "first")
+				return errFn
+			},
+			expected: []string{"rollback first"},
+		},
+		{
+			name: "bound to outermost transaction",
+			fn: func(ctx context.Context, calls *[]string) error {
+				register(ctx, calls, "outer")
+
+				err := tr.WithTx(ctx, func(ctx context.Context) error {
+					register(ctx, calls, "inner")
+					return nil
+				})
+				require.NoError(t, err)
+
+				// nothing runs before the outermost commit
+				require.Empty(t, *calls)
+
+				return nil
+			},
+			expected: []string{"commit outer", "commit inner"},
+		},
+		{
+			name: "rolled back savepoint",
+			fn: func(ctx context.Context, calls *[]string) error {
+				register(ctx, calls, "outer")
+
+				err := tr.WithTx(ctx, func(ctx context.Context) error {
+					register(ctx, calls, "inner")
+					return errFn
+				})
+				require.ErrorIs(t, err, errFn)
+
+				return nil
+			},
+			expected: []string{"rollback inner", "commit outer"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			var calls []string
+
+			_ = tr.WithTx(context.Background(), func(ctx context.Context) error {
+				return tc.fn(ctx, &calls)
+			})
+
+			assert.Equal(st, tc.expected, calls)
+		})
+	}
+}
+
+func TestHooksOnPanic(t *testing.T) {
+	t.Parallel()
+
+	tr := transactor.New(transactortest.New())
+
+	var calls []string
+
+	assert.Panics(t, func() {
+		_ = tr.WithTx(context.Background(), func(ctx context.Context) error {
+			register(ctx, &calls, "first")
+			panic("boom")
+		})
+	})
+
+	assert.Equal(t, []string{"rollback first"}, calls)
+}
+
+func register(ctx context.Context, calls *[]string, name string) {
+	transactor.AfterCommit(ctx, func(context.Context) {
+		*calls = append(*calls, "commit "+name)
+	})
+	transactor.AfterRollback(ctx, func(context.Context) {
+		*calls = append(*calls, "rollback "+name)
+	})
+}
+
+func TestRollbackHookRegistersHooks(t *testing.T) {
+	t.Parallel()
+
+	tr := transactor.New(transactortest.New())
+
+	var calls []string
+
+	errFn := errors.New("fn")
+
+	err := tr.WithTx(context.Background(), func(outer context.Context) error {
+		err := tr.WithTx(outer, func(ctx context.Context) error {
+			transactor.AfterRollback(ctx, func(context.Context) {
+				calls = append(calls, "first")
+
+				// registered in the outer transaction while the savepoint hooks run
+				transactor.AfterRollback(outer, func(context.Context) {})
+				transactor.AfterRollback(outer, func(context.Context) {})
+			})
+			transactor.AfterRollback(ctx, func(context.Context) {
+				calls = append(calls, "second")
+			})
+
+			return errFn
+		})
+		require.ErrorIs(t, err, errFn)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"first", "second"}, calls)
+}
+
+func TestHooksOfPanickedSavepoint(t *testing.T) {
+	t.Parallel()
+
+	tr := transactor.New(transactortest.New())
+
+	var calls []string
+
+	err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+		register(ctx, &calls, "outer")
+
+		assert.Panics(t, func() {
+			_ = tr.WithTx(ctx, func(ctx context.Context) error {
+				register(ctx, &calls, "inner")
+				panic("boom")
+			})
+		})
+
+		// the outer transaction recovers and commits
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"rollback inner", "commit outer"}, calls)
+}
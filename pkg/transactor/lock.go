@@ -0,0 +1,147 @@
+package transactor
+
+import (
+	"context"
+	stdErrors "errors"
+	"hash/fnv"
+
+	"github.com/jackc/pgx/v5"
+	pkgErrors "github.com/pkg/errors"
+)
+
+// LockMode defines the scope of an advisory lock
+type LockMode int
+
+const (
+	// Wait for a transaction-scoped lock
+	// The lock is held until the outermost transaction ends
+	LockXact LockMode = iota
+	// Try a transaction-scoped lock
+	// Fail with ErrLockNotAcquired if it is held by someone else
+	LockXactTry
+	// Try a session-scoped lock on a dedicated connection
+	// The lock is held only while fn runs, fn may use any transactions
+	// Fail with ErrLockNotAcquired if it is held by someone else
+	// The database must implement Acquirer
+	LockSessionTry
+	// Wait for a session-scoped lock on a dedicated connection
+	// The lock is held only while fn runs, fn may use any transactions
+	// The database must implement Acquirer
+	LockSession
+)
+
+// Hashing a lock name into the key of Postgres advisory locks
+func LockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+
+	return int64(h.Sum64())
+}
+
+// Do fn under a transaction-scoped advisory lock
+// fn joins the transaction in context or runs in a new one
+func (t Transactor) WithAdvisoryLock(
+	ctx context.Context,
+	key string,
+	fn func(context.Context) error,
+) error {
+	return t.WithAdvisoryLockMode(ctx, key, fn, LockXact)
+}
+
+// Do fn under an advisory lock of the given mode
+func (t Transactor) WithAdvisoryLockMode(
+	ctx context.Context,
+	key string,
+	fn func(context.Context) error,
+	mode LockMode,
+) error {
+	switch mode {
+	case LockXact, LockXactTry:
+		return t.WithTxPropagation(ctx, func(ctx context.Context) error {
+			if err := t.xactLock(ctx, key, mode == LockXactTry); err != nil {
+				return err
+			}
+
+			return fn(ctx)
+		}, pgx.TxOptions{}, PropagationRequired)
+	case LockSession, LockSessionTry:
+		return t.withSessionLock(ctx, key, fn, mode == LockSessionTry)
+	default:
+		return pkgErrors.Errorf("unknown lock mode %d", mode)
+	}
+}
+
+// Taking a transaction-scoped lock in the transaction in context
+func (t Transactor) xactLock(ctx context.Context, key string, try bool) error {
+	if !try {
+		_, err := t.ExtractTx(ctx).Exec(ctx, "SELECT pg_advisory_xact_lock($1)", LockKey(key))
+		return pkgErrors.Wrapf(err, "lock %q", key)
+	}
+
+	var acquired bool
+
+	err := t.ExtractTx(ctx).
+		QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", LockKey(key)).
+		Scan(&acquired)
+	if err != nil {
+		return pkgErrors.Wrapf(err, "try lock %q", key)
+	}
+
+	if !acquired {
+		return pkgErrors.Wrapf(ErrLockNotAcquired, "%q", key)
+	}
+
+	return nil
+}
+
+// Holding a session-scoped lock on a dedicated connection while fn runs
+func (t Transactor) withSessionLock(
+	ctx context.Context,
+	key string,
+	fn func(context.Context) error,
+	try bool,
+) (err error) {
+	acquirer, ok := t.db.(Acquirer)
+	if !ok {
+		return ErrSessionLocksUnsupported
+	}
+
+	conn, err := acquirer.Acquire(ctx)
+	if err != nil {
+		return pkgErrors.Wrap(err, "acquire connection")
+	}
+
+	acquired := true
+
+	if try {
+		err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", LockKey(key)).Scan(&acquired)
+	} else {
+		_, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", LockKey(key))
+	}
+
+	if err != nil {
+		discard(conn)
+		return pkgErrors.Wrapf(err, "lock %q", key)
+	}
+
+	if !acquired {
+		conn.Release()
+		return pkgErrors.Wrapf(ErrLockNotAcquired, "%q", key)
+	}
+
+	defer func() {
+		// the lock must not stay with the connection returned to the pool
+		_, unlockErr := conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", LockKey(key))
+		if unlockErr != nil {
+			// closed connection is destroyed by the pool, and the lock with it
+			discard(conn)
+			err = stdErrors.Join(err, pkgErrors.Wrapf(unlockErr, "unlock %q", key))
+
+			return
+		}
+
+		conn.Release()
+	}()
+
+	return fn(ctx)
+}
@@ -0,0 +1,127 @@
+package transactor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/internal/pgtest"
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockKey(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, transactor.LockKey("reports"), transactor.LockKey("reports"))
+	assert.NotEqual(t, transactor.LockKey("reports"), transactor.LockKey("billing"))
+}
+
+func TestTryLockHeld(t *testing.T) {
+	t.Parallel()
+
+	pool := pgtest.Connect(t)
+	tr := transactor.New(pool)
+
+	testCases := []struct {
+		name string
+		mode transactor.LockMode
+	}{
+		{name: "xact", mode: transactor.LockXactTry},
+		{name: "session", mode: transactor.LockSessionTry},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			key := "held_" + st.Name()
+
+			err := tr.WithAdvisoryLock(context.Background(), key, func(ctx context.Context) error {
+				// another session can not take the lock
+				err := tr.WithAdvisoryLockMode(context.Background(), key, func(context.Context) error {
+					st.Fatal("lock is taken twice")
+					return nil
+				}, tc.mode)
+				require.ErrorIs(st, err, transactor.ErrLockNotAcquired)
+
+				return nil
+			})
+			require.NoError(st, err)
+
+			// the lock is released with the transaction
+			ran := false
+
+			err = tr.WithAdvisoryLockMode(context.Background(), key, func(context.Context) error {
+				ran = true
+				return nil
+			}, tc.mode)
+			require.NoError(st, err)
+			assert.True(st, ran)
+		})
+	}
+}
+
+func TestElectorFailover(t *testing.T) {
+	t.Parallel()
+
+	pool := pgtest.Connect(t)
+	tr := transactor.New(pool)
+
+	config := transactor.ElectorConfig{
+		RetryInterval: 10 * time.Millisecond,
+		CheckInterval: 10 * time.Millisecond,
+	}
+
+	first := tr.NewElector(t.Name(), config)
+	require.NoError(t, first.Start(context.Background()))
+	require.Equal(t, transactor.LeadershipGained, <-first.Events())
+
+	second := tr.NewElector(t.Name(), config)
+	require.NoError(t, second.Start(context.Background()))
+
+	t.Cleanup(func() {
+		_ = second.Stop(context.Background())
+	})
+
+	// the second one keeps trying while the first one leads
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, second.IsLeader())
+
+	require.NoError(t, first.Stop(context.Background()))
+	assert.Equal(t, transactor.LeadershipLost, <-first.Events())
+	assert.False(t, first.IsLeader())
+
+	select {
+	case event := <-second.Events():
+		assert.Equal(t, transactor.LeadershipGained, event)
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership is not taken over")
+	}
+}
+
+func TestElectorEventsNotRead(t *testing.T) {
+	t.Parallel()
+
+	pool := pgtest.Connect(t)
+	tr := transactor.New(pool)
+
+	elector := tr.NewElector(t.Name(), transactor.ElectorConfig{
+		RetryInterval: 10 * time.Millisecond,
+		CheckInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, elector.Start(context.Background()))
+
+	require.Eventually(t, elector.IsLeader, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// nobody reads events, Stop must not block on them
+	require.NoError(t, elector.Stop(ctx))
+
+	// only the latest event is kept
+	assert.Equal(t, transactor.LeadershipLost, <-elector.Events())
+	assert.Empty(t, elector.Events())
+}
@@ -0,0 +1,170 @@
+package transactor
+
+import (
+	"context"
+	"database/sql"
+	stdErrors "errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	pkgErrors "github.com/pkg/errors"
+)
+
+// Query all rows as T
+// Structs are scanned by column names matched to fields or their db tags,
+// other types and a single column matching no field are scanned from the column
+func QueryAll[T any](ctx context.Context, q Querier, sql string, args ...any) ([]T, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "query")
+	}
+
+	result, err := pgx.CollectRows(rows, rowTo[T]())
+
+	return result, pkgErrors.Wrap(err, "collect rows")
+}
+
+// Query exactly one row as T
+// Returns ErrNotFound if there are no rows
+func QueryOne[T any](ctx context.Context, q Querier, sql string, args ...any) (T, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		var zero T
+		return zero, pkgErrors.Wrap(err, "query")
+	}
+
+	result, err := pgx.CollectExactlyOneRow(rows, rowTo[T]())
+	if stdErrors.Is(err, pgx.ErrNoRows) {
+		return result, ErrNotFound
+	}
+
+	return result, pkgErrors.Wrap(err, "collect row")
+}
+
+// Query at most one row as T
+// Returns nil if there are no rows
+func QueryMaybe[T any](ctx context.Context, q Querier, sql string, args ...any) (*T, error) {
+	result, err := QueryOne[T](ctx, q, sql, args...)
+	if stdErrors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Query all rows as T mapped by key
+// Rows with the same key overwrite each other
+func QueryMap[K comparable, T any](
+	ctx context.Context,
+	q Querier,
+	key func(T) K,
+	sql string,
+	args ...any,
+) (map[K]T, error) {
+	all, err := QueryAll[T](ctx, q, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[K]T, len(all))
+	for _, item := range all {
+		result[key(item)] = item
+	}
+
+	return result, nil
+}
+
+// Do fn in transaction and return its result
+// The zero value is returned if the transaction fails
+func WithTxResult[T any](
+	ctx context.Context,
+	t *Transactor,
+	fn func(context.Context) (T, error),
+) (T, error) {
+	var result T
+
+	err := t.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+
+		result, err = fn(ctx)
+
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return result, nil
+}
+
+var (
+	scannerType = reflect.TypeFor[sql.Scanner]()
+	timeType    = reflect.TypeFor[time.Time]()
+)
+
+// Choosing how to scan a row into T
+func rowTo[T any]() pgx.RowToFunc[T] {
+	typ := reflect.TypeFor[T]()
+
+	// types that scan themselves are scanned from a single column
+	// even if they are structs
+	isStruct := typ.Kind() == reflect.Struct &&
+		typ != timeType &&
+		!reflect.PointerTo(typ).Implements(scannerType)
+
+	if !isStruct {
+		return pgx.RowTo[T]
+	}
+
+	return func(row pgx.CollectableRow) (T, error) {
+		// a single column that is not a field holds the whole struct,
+		// e.g. a composite type or json
+		columns := row.FieldDescriptions()
+		if len(columns) == 1 && !hasField(typ, columns[0].Name) {
+			return pgx.RowTo[T](row)
+		}
+
+		return pgx.RowToStructByName[T](row)
+	}
+}
+
+// Whether RowToStructByName maps the column to a field of the struct
+// Fields are matched by db tags as is, by names ignoring case and underscores
+func hasField(typ reflect.Type, column string) bool {
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if hasField(field.Type, column) {
+				return true
+			}
+
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if tag, ok := field.Tag.Lookup("db"); ok {
+			if name, _, _ := strings.Cut(tag, ","); name == column {
+				return true
+			}
+
+			continue
+		}
+
+		if strings.EqualFold(strings.ReplaceAll(field.Name, "_", ""), strings.ReplaceAll(column, "_", "")) {
+			return true
+		}
+	}
+
+	return false
+}
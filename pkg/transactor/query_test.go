@@ -0,0 +1,235 @@
+package transactor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/bogi-lyceya-44/common/pkg/transactor/transactortest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type user struct {
+	ID        int64     `db:"id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+	Email     *string   `db:"email"`
+}
+
+var createdAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newUsers returns a querier with users table
+// of alice and bob, filtered by name if it is given as the argument
+func newUsers() transactor.Querier {
+	email := "bob@example.com"
+	rows := [][]any{
+		{int64(1), "alice", createdAt, nil},
+		{int64(2), "bob", createdAt, email},
+	}
+
+	fake := transactortest.New()
+	fake.OnQuery = func(_ string, args []any) (transactortest.Result, error) {
+		result := transactortest.Result{
+			Columns: []string{"id", "name", "created_at", "email"},
+		}
+
+		for _, row := range rows {
+			if len(args) == 0 || args[0] == row[1] {
+				result.Rows = append(result.Rows, row)
+			}
+		}
+
+		return result, nil
+	}
+
+	return transactor.New(fake).ExtractTx(context.Background())
+}
+
+func TestQueryAll(t *testing.T) {
+	t.Parallel()
+
+	users, err := transactor.QueryAll[user](context.Background(), newUsers(), "SELECT * FROM users")
+	require.NoError(t, err)
+
+	email := "bob@example.com"
+
+	assert.Equal(t, []user{
+		{ID: 1, Name: "alice", CreatedAt: createdAt},
+		{ID: 2, Name: "bob", CreatedAt: createdAt, Email: &email},
+	}, users)
+}
+
+func TestQueryScalar(t *testing.T) {
+	t.Parallel()
+
+	fake := transactortest.New()
+	fake.OnQuery = func(string, []any) (transactortest.Result, error) {
+		return transactortest.Result{Rows: [][]any{{int32(3)}, {int32(5)}}}, nil
+	}
+
+	q := transactor.New(fake).ExtractTx(context.Background())
+
+	ids, err := transactor.QueryAll[int](context.Background(), q, "SELECT id FROM users")
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 5}, ids)
+}
+
+type address struct {
+	City   string
+	Street string
+}
+
+type named struct {
+	Name string `db:"name"`
+}
+
+func TestQuerySingleColumnStruct(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		column   string
+		value    any
+		expected any
+		query    func(q transactor.Querier) (any, error)
+	}{
+		{
+			name:     "composite column",
+			column:   "address",
+			value:    address{City: "Moscow", Street: "Tverskaya"},
+			expected: []address{{City: "Moscow", Street: "Tverskaya"}},
+			query: func(q transactor.Querier) (any, error) {
+				return transactor.QueryAll[address](context.Background(), q, "SELECT address FROM users")
+			},
+		},
+		{
+			name:     "field column",
+			column:   "name",
+			value:    "alice",
+			expected: []named{{Name: "alice"}},
+			query: func(q transactor.Querier) (any, error) {
+				return transactor.QueryAll[named](context.Background(), q, "SELECT name FROM users")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			fake := transactortest.New()
+			fake.OnQuery = func(string, []any) (transactortest.Result, error) {
+				return transactortest.Result{
+					Columns: []string{tc.column},
+					Rows:    [][]any{{tc.value}},
+				}, nil
+			}
+
+			result, err := tc.query(transactor.New(fake).ExtractTx(context.Background()))
+			require.NoError(st, err)
+			assert.Equal(st, tc.expected, result)
+		})
+	}
+}
+
+func TestQueryOne(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		args     []any
+		expected user
+		err      error
+	}{
+		{
+			name:     "found",
+			args:     []any{"alice"},
+			expected: user{ID: 1, Name: "alice", CreatedAt: createdAt},
+		},
+		{
+			name: "not found",
+			args: []any{"carol"},
+			err:  transactor.ErrNotFound,
+		},
+		{
+			name: "too many",
+			err:  errors.New("too many rows"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			got, err := transactor.QueryOne[user](
+				context.Background(),
+				newUsers(),
+				"SELECT * FROM users WHERE name = $1",
+				tc.args...,
+			)
+
+			switch {
+			case tc.err == nil:
+				require.NoError(st, err)
+				assert.Equal(st, tc.expected, got)
+			case errors.Is(tc.err, transactor.ErrNotFound):
+				require.ErrorIs(st, err, transactor.ErrNotFound)
+			default:
+				require.ErrorContains(st, err, tc.err.Error())
+			}
+		})
+	}
+}
+
+func TestQueryMaybe(t *testing.T) {
+	t.Parallel()
+
+	found, err := transactor.QueryMaybe[user](context.Background(), newUsers(), "SELECT", "alice")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "alice", found.Name)
+
+	missing, err := transactor.QueryMaybe[user](context.Background(), newUsers(), "SELECT", "carol")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestQueryMap(t *testing.T) {
+	t.Parallel()
+
+	byName, err := transactor.QueryMap(
+		context.Background(),
+		newUsers(),
+		func(u user) string { return u.Name },
+		"SELECT * FROM users",
+	)
+	require.NoError(t, err)
+
+	require.Len(t, byName, 2)
+	assert.Equal(t, int64(1), byName["alice"].ID)
+	assert.Equal(t, int64(2), byName["bob"].ID)
+}
+
+func TestWithTxResult(t *testing.T) {
+	t.Parallel()
+
+	fake := transactortest.New()
+	tr := transactor.New(fake)
+
+	id, err := transactor.WithTxResult(context.Background(), tr, func(context.Context) (int64, error) {
+		return 42, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+
+	errFn := errors.New("fn")
+
+	id, err = transactor.WithTxResult(context.Background(), tr, func(context.Context) (int64, error) {
+		return 42, errFn
+	})
+	require.ErrorIs(t, err, errFn)
+	assert.Zero(t, id)
+}
@@ -0,0 +1,249 @@
+package transactor
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Way of choosing a replica for a read
+type ReplicaPolicy int
+
+const (
+	// Take healthy replicas in turn
+	ReplicaRoundRobin ReplicaPolicy = iota
+	// Take the healthy replica with the fewest acquired connections
+	// Replicas that do not report their stats are taken as idle
+	ReplicaLeastConnections
+)
+
+// Longest wait for a replica ping of RunReplicaHealthCheck
+const replicaPingTimeout = time.Second
+
+// Primary force key in context
+type primaryForcer struct{}
+
+// Replica pools with their health
+type replicaSet struct {
+	pools   []Beginner
+	healthy []atomic.Bool
+	policy  ReplicaPolicy
+	next    atomic.Uint64
+}
+
+// Route read-only transactions and plain SELECTs of ExtractTx outside of transaction to replicas
+// Replicas are considered healthy until CheckReplicas says otherwise
+func WithReplicas(replicas ...Beginner) Option {
+	return func(t *Transactor) {
+		if len(replicas) == 0 {
+			return
+		}
+
+		set := &replicaSet{
+			pools:   replicas,
+			healthy: make([]atomic.Bool, len(replicas)),
+		}
+
+		if t.replicas != nil {
+			set.policy = t.replicas.policy
+		}
+
+		for i := range set.healthy {
+			set.healthy[i].Store(true)
+		}
+
+		t.replicas = set
+	}
+}
+
+// Set the way of choosing a replica
+// Default is ReplicaRoundRobin
+func WithReplicaPolicy(policy ReplicaPolicy) Option {
+	return func(t *Transactor) {
+		if t.replicas == nil {
+			t.replicas = &replicaSet{}
+		}
+
+		t.replicas.policy = policy
+	}
+}
+
+// Route reads of ExtractTx and read-only transactions made with ctx to the primary,
+// e.g. to read own writes that are not replicated yet
+// or to run a SELECT with side effects, like nextval or pg_advisory_lock
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryForcer{}, true)
+}
+
+func isPrimaryForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryForcer{}).(bool)
+	return forced
+}
+
+// Replica that can be health checked, implemented by *pgxpool.Pool
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Replica that reports its stats, implemented by *pgxpool.Pool
+type statReporter interface {
+	Stat() *pgxpool.Stat
+}
+
+// Ping every replica and take unreachable ones out of rotation
+// until the next check finds them reachable again
+// Replicas that can not be pinged are always healthy
+func (t Transactor) CheckReplicas(ctx context.Context, timeout time.Duration) {
+	if t.replicas == nil {
+		return
+	}
+
+	for i, replica := range t.replicas.pools {
+		p, ok := replica.(pinger)
+		if !ok {
+			continue
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := p.Ping(pingCtx)
+		cancel()
+
+		t.replicas.healthy[i].Store(err == nil)
+	}
+}
+
+// Check replicas every interval until ctx is done
+// A ping waits for half of the interval, but no longer than a second
+func (t Transactor) RunReplicaHealthCheck(ctx context.Context, interval time.Duration) {
+	ticker := t.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	timeout := min(interval/2, replicaPingTimeout)
+
+	for {
+		t.CheckReplicas(ctx, timeout)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Pool for a new transaction with the given options
+func (t Transactor) poolFor(ctx context.Context, opts pgx.TxOptions) Beginner {
+	if opts.AccessMode != pgx.ReadOnly || isPrimaryForced(ctx) {
+		return t.db
+	}
+
+	if replica := t.replicas.pick(); replica != nil {
+		return replica
+	}
+
+	return t.db
+}
+
+// Choosing a healthy replica
+// Returns nil if there is none
+func (s *replicaSet) pick() Beginner {
+	if s == nil {
+		return nil
+	}
+
+	healthy := make([]Beginner, 0, len(s.pools))
+
+	for i, pool := range s.pools {
+		if s.healthy[i].Load() {
+			healthy = append(healthy, pool)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	if s.policy == ReplicaLeastConnections {
+		least, leastConns := healthy[0], acquiredConns(healthy[0])
+
+		for _, replica := range healthy[1:] {
+			if conns := acquiredConns(replica); conns < leastConns {
+				least, leastConns = replica, conns
+			}
+		}
+
+		return least
+	}
+
+	return healthy[(s.next.Add(1)-1)%uint64(len(healthy))]
+}
+
+func acquiredConns(replica Beginner) int32 {
+	if s, ok := replica.(statReporter); ok {
+		return s.Stat().AcquiredConns()
+	}
+
+	return 0
+}
+
+// Querier of ExtractTx outside of transaction with replicas
+// Query and QueryRow of a plain SELECT read from the replica,
+// everything else goes to the primary
+type routedQuerier struct {
+	primary Querier
+	replica Querier
+}
+
+var lockingClause = regexp.MustCompile(`(?i)\bFOR\s+(NO\s+KEY\s+)?(UPDATE|SHARE|KEY\s+SHARE)\b`)
+
+// Whether sql is a SELECT without a locking clause
+// WITH is not a read, since its statements may write
+func isRead(sql string) bool {
+	sql = strings.TrimSpace(sql)
+
+	if len(sql) < len("SELECT") || !strings.EqualFold(sql[:len("SELECT")], "SELECT") {
+		return false
+	}
+
+	return !lockingClause.MatchString(sql)
+}
+
+func (q routedQuerier) route(sql string) Querier {
+	if isRead(sql) {
+		return q.replica
+	}
+
+	return q.primary
+}
+
+func (q routedQuerier) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return q.primary.Exec(ctx, sql, args...)
+}
+
+func (q routedQuerier) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return q.route(sql).Query(ctx, sql, args...)
+}
+
+func (q routedQuerier) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return q.route(sql).QueryRow(ctx, sql, args...)
+}
+
+func (q routedQuerier) CopyFrom(
+	ctx context.Context,
+	tableName pgx.Identifier,
+	columnNames []string,
+	rowSrc pgx.CopyFromSource,
+) (int64, error) {
+	return q.primary.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+// Batches may contain writes, so they are not routed to the replica
+func (q routedQuerier) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return q.primary.SendBatch(ctx, b)
+}
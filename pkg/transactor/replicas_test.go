@@ -0,0 +1,302 @@
+package transactor_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/internal/pgtest"
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/bogi-lyceya-44/common/pkg/transactor/transactortest"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newReplica connects another pool to the test database,
+// which stands for a replica
+func newReplica(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	return pgtest.Connect(t)
+}
+
+func TestReplicaLeastConnections(t *testing.T) {
+	t.Parallel()
+
+	primary := pgtest.Connect(t)
+	busy, idle := newReplica(t), newReplica(t)
+	tr := transactor.New(
+		primary,
+		transactor.WithReplicas(busy, idle),
+		transactor.WithReplicaPolicy(transactor.ReplicaLeastConnections),
+	)
+
+	conn, err := busy.Acquire(context.Background())
+	require.NoError(t, err)
+
+	defer conn.Release()
+
+	for range 3 {
+		var one int
+
+		err := tr.ExtractTx(context.Background()).QueryRow(context.Background(), "SELECT 1").Scan(&one)
+		require.NoError(t, err)
+	}
+
+	assert.Zero(t, primary.Stat().AcquireCount())
+	assert.Equal(t, int64(1), busy.Stat().AcquireCount())
+	assert.Equal(t, int64(3), idle.Stat().AcquireCount())
+}
+
+// unreachableReplica is a fake replica that fails health checks
+type unreachableReplica struct {
+	*transactortest.Fake
+}
+
+func (unreachableReplica) Ping(context.Context) error {
+	return errors.New("connection refused")
+}
+
+// flakyReplica is a fake replica that fails health checks while down
+// and remembers the longest ping timeout
+type flakyReplica struct {
+	*transactortest.Fake
+	down        atomic.Bool
+	pingTimeout atomic.Int64
+}
+
+func (r *flakyReplica) Ping(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		r.pingTimeout.Store(max(r.pingTimeout.Load(), int64(time.Until(deadline))))
+	}
+
+	if r.down.Load() {
+		return errors.New("connection refused")
+	}
+
+	return nil
+}
+
+func TestReplicaRouting(t *testing.T) {
+	t.Parallel()
+
+	query := func(sql string) func(ctx context.Context, tr *transactor.Transactor) error {
+		return func(ctx context.Context, tr *transactor.Transactor) error {
+			_, err := tr.ExtractTx(ctx).Query(ctx, sql)
+			return err
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		run       func(ctx context.Context, tr *transactor.Transactor) error
+		onReplica bool
+	}{
+		{
+			name:      "select outside transaction",
+			run:       query("SELECT"),
+			onReplica: true,
+		},
+		{
+			name:      "lowercase select outside transaction",
+			run:       query("\n\tselect id FROM jobs"),
+			onReplica: true,
+		},
+		{
+			name: "query row outside transaction",
+			run: func(ctx context.Context, tr *transactor.Transactor) error {
+				err := tr.ExtractTx(ctx).QueryRow(ctx, "SELECT").Scan()
+				if errors.Is(err, pgx.ErrNoRows) {
+					return nil
+				}
+
+				return err
+			},
+			onReplica: true,
+		},
+		{
+			name: "insert returning outside transaction",
+			run:  query("INSERT INTO jobs DEFAULT VALUES RETURNING id"),
+		},
+		{
+			name: "with outside transaction",
+			run:  query("WITH claimed AS (DELETE FROM jobs RETURNING id) SELECT id FROM claimed"),
+		},
+		{
+			name: "select for update outside transaction",
+			run:  query("SELECT id FROM jobs FOR UPDATE SKIP LOCKED"),
+		},
+		{
+			name: "select for no key update outside transaction",
+			run:  query("SELECT id FROM jobs for no key update"),
+		},
+		{
+			name: "select with forced primary",
+			run: func(ctx context.Context, tr *transactor.Transactor) error {
+				return query("SELECT")(transactor.ForcePrimary(ctx), tr)
+			},
+		},
+		{
+			name: "exec outside transaction",
+			run: func(ctx context.Context, tr *transactor.Transactor) error {
+				_, err := tr.ExtractTx(ctx).Exec(ctx, "SELECT")
+				return err
+			},
+		},
+		{
+			name: "read only transaction",
+			run: func(ctx context.Context, tr *transactor.Transactor) error {
+				return tr.WithTxOpts(ctx, func(ctx context.Context) error {
+					_, err := tr.ExtractTx(ctx).Query(ctx, "SELECT")
+					return err
+				}, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+			},
+			onReplica: true,
+		},
+		{
+			name: "read only transaction with forced primary",
+			run: func(ctx context.Context, tr *transactor.Transactor) error {
+				return tr.WithTxOpts(transactor.ForcePrimary(ctx), func(ctx context.Context) error {
+					_, err := tr.ExtractTx(ctx).Query(ctx, "SELECT")
+					return err
+				}, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+			},
+		},
+		{
+			name: "read write transaction",
+			run: func(ctx context.Context, tr *transactor.Transactor) error {
+				return tr.WithTx(ctx, func(ctx context.Context) error {
+					_, err := tr.ExtractTx(ctx).Query(ctx, "SELECT")
+					return err
+				})
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			primary := transactortest.New()
+			replica := transactortest.New()
+			tr := transactor.New(primary, transactor.WithReplicas(replica))
+
+			require.NoError(st, tc.run(context.Background(), tr))
+
+			used, unused := primary, replica
+			if tc.onReplica {
+				used, unused = replica, primary
+			}
+
+			assert.Len(st, used.SQL(), 1)
+			assert.Empty(st, unused.Events())
+		})
+	}
+}
+
+func TestReplicaRoundRobin(t *testing.T) {
+	t.Parallel()
+
+	primary := transactortest.New()
+	replicas := []*transactortest.Fake{transactortest.New(), transactortest.New()}
+	tr := transactor.New(primary, transactor.WithReplicas(replicas[0], replicas[1]))
+
+	for range 4 {
+		_, err := tr.ExtractTx(context.Background()).Query(context.Background(), "SELECT")
+		require.NoError(t, err)
+	}
+
+	assert.Empty(t, primary.Events())
+
+	for _, replica := range replicas {
+		assert.Len(t, replica.SQL(), 2)
+	}
+}
+
+func TestUnhealthyReplicaSkipped(t *testing.T) {
+	t.Parallel()
+
+	primary := transactortest.New()
+	broken := unreachableReplica{transactortest.New()}
+	healthy := transactortest.New()
+
+	tr := transactor.New(primary, transactor.WithReplicas(broken, healthy))
+	tr.CheckReplicas(context.Background(), time.Second)
+
+	for range 2 {
+		_, err := tr.ExtractTx(context.Background()).Query(context.Background(), "SELECT")
+		require.NoError(t, err)
+	}
+
+	assert.Empty(t, broken.Events())
+	assert.Len(t, healthy.SQL(), 2)
+
+	// without healthy replicas reads fall back to the primary
+	tr = transactor.New(primary, transactor.WithReplicas(broken))
+	tr.CheckReplicas(context.Background(), time.Second)
+
+	_, err := tr.ExtractTx(context.Background()).Query(context.Background(), "SELECT")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"SELECT"}, primary.SQL())
+}
+
+func TestRunReplicaHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	primary := transactortest.New()
+	replica := &flakyReplica{Fake: transactortest.New()}
+	replica.down.Store(true)
+
+	tr := transactor.New(primary, transactor.WithClock(clk), transactor.WithReplicas(replica))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		tr.RunReplicaHealthCheck(ctx, time.Hour)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	readsFrom := func(target *transactortest.Fake) func() bool {
+		return func() bool {
+			primary.Reset()
+			replica.Reset()
+
+			_, err := tr.ExtractTx(context.Background()).Query(context.Background(), "SELECT")
+			require.NoError(t, err)
+
+			return len(target.SQL()) == 1
+		}
+	}
+
+	// the first check runs right away and takes the replica out
+	require.Eventually(t, readsFrom(primary), 5*time.Second, time.Millisecond)
+
+	// the next check brings it back
+	replica.down.Store(false)
+
+	require.Eventually(
+		t,
+		func() bool {
+			clk.Advance(time.Hour)
+			return readsFrom(replica.Fake)()
+		},
+		5*time.Second,
+		time.Millisecond,
+	)
+
+	// a ping does not wait for the whole interval
+	assert.LessOrEqual(t, time.Duration(replica.pingTimeout.Load()), time.Second)
+}
@@ -0,0 +1,118 @@
+package transactor
+
+import (
+	"context"
+	stdErrors "errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	pkgErrors "github.com/pkg/errors"
+)
+
+// SQLSTATE codes of errors that may succeed on retry
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+)
+
+// Retry policy of outermost transactions
+type RetryPolicy struct {
+	// Total number of attempts including the first one
+	MaxAttempts int
+	// Delay before the first retry, doubled on every next one
+	BaseDelay time.Duration
+	// Upper bound of the delay
+	MaxDelay time.Duration
+	// Reports whether the error is worth a retry
+	// Default is IsRetryable
+	Classifier func(err error) bool
+}
+
+// Serialization failures and deadlocks are retryable
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !stdErrors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == codeSerializationFailure ||
+		pgErr.Code == codeDeadlockDetected
+}
+
+// Enable retries of outermost transactions
+// The whole fn is run again in a fresh transaction,
+// so it must not have side effects outside of the database
+// Use AfterCommit for such side effects
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(t *Transactor) {
+		if policy.Classifier == nil {
+			policy.Classifier = IsRetryable
+		}
+
+		t.retry = &policy
+	}
+}
+
+// Number of the current attempt of the outermost transaction
+// Returns 0 if there is no transaction in context
+func Attempt(ctx context.Context) int {
+	state, ok := ctx.Value(txInjector{}).(*txState)
+	if !ok {
+		return 0
+	}
+
+	return state.root.attempt
+}
+
+// Running attempts until success, non-retryable error or exhausted policy
+func (t Transactor) withRetry(
+	ctx context.Context,
+	attemptFn func(attempt int) error,
+) error {
+	if t.retry == nil || t.retry.MaxAttempts <= 1 {
+		return attemptFn(1)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := attemptFn(attempt)
+		if err == nil {
+			return nil
+		}
+
+		if !t.retry.Classifier(err) {
+			return err
+		}
+
+		if attempt >= t.retry.MaxAttempts {
+			return pkgErrors.Wrapf(err, "tx failed after %d attempts", attempt)
+		}
+
+		timer := t.clock.NewTimer(t.retry.delay(attempt))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return stdErrors.Join(
+				err,
+				pkgErrors.Wrapf(ctx.Err(), "retry after %d attempts", attempt),
+			)
+		case <-timer.C():
+		}
+	}
+}
+
+// Exponential backoff with full jitter
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	backoff := p.BaseDelay << (attempt - 1)
+	if backoff <= 0 || (p.MaxDelay > 0 && backoff > p.MaxDelay) {
+		backoff = p.MaxDelay
+	}
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	return rand.N(backoff + 1)
+}
@@ -0,0 +1,243 @@
+package transactor_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/bogi-lyceya-44/common/pkg/transactor/transactortest"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failCommits makes the first n commits of outermost transactions fail with code.
+func failCommits(fake *transactortest.Fake, n int64, code string) {
+	var failed atomic.Int64
+
+	fake.OnCommit = func(depth int) error {
+		if depth == 1 && failed.Add(1) <= n {
+			return &pgconn.PgError{Code: code}
+		}
+
+		return nil
+	}
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	errFn := errors.New("fn")
+
+	testCases := []struct {
+		name     string
+		failures int64
+		code     string
+		fnErr    error
+		attempts []int
+		check    func(t *testing.T, err error)
+	}{
+		{
+			name:     "serialization failure",
+			failures: 2,
+			code:     "40001",
+			attempts: []int{1, 2, 3},
+			check: func(t *testing.T, err error) {
+				require.NoError(t, err)
+			},
+		},
+		{
+			name:     "deadlock",
+			failures: 1,
+			code:     "40P01",
+			attempts: []int{1, 2},
+			check: func(t *testing.T, err error) {
+				require.NoError(t, err)
+			},
+		},
+		{
+			name:     "exhausted attempts",
+			failures: 3,
+			code:     "40001",
+			attempts: []int{1, 2, 3},
+			check: func(t *testing.T, err error) {
+				var pgErr *pgconn.PgError
+
+				require.ErrorAs(t, err, &pgErr)
+				assert.Equal(t, "40001", pgErr.Code)
+				assert.ErrorContains(t, err, "after 3 attempts")
+			},
+		},
+		{
+			name:     "not retryable code",
+			failures: 1,
+			code:     "23505",
+			attempts: []int{1},
+			check: func(t *testing.T, err error) {
+				var pgErr *pgconn.PgError
+
+				require.ErrorAs(t, err, &pgErr)
+				assert.Equal(t, "23505", pgErr.Code)
+			},
+		},
+		{
+			name:     "error of fn",
+			fnErr:    errFn,
+			attempts: []int{1},
+			check: func(t *testing.T, err error) {
+				require.ErrorIs(t, err, errFn)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			fake := transactortest.New()
+			failCommits(fake, tc.failures, tc.code)
+
+			tr := transactor.New(
+				fake,
+				// zero delays fire right away on the fake clock
+				transactor.WithClock(clock.NewFake(time.Now())),
+				transactor.WithRetryPolicy(transactor.RetryPolicy{MaxAttempts: 3}),
+			)
+
+			var attempts []int
+
+			err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+				attempts = append(attempts, transactor.Attempt(ctx))
+				return tc.fnErr
+			})
+
+			tc.check(st, err)
+			assert.Equal(st, tc.attempts, attempts)
+		})
+	}
+}
+
+func TestRetrySavepointNotRetried(t *testing.T) {
+	t.Parallel()
+
+	fake := transactortest.New()
+	fake.OnCommit = func(depth int) error {
+		if depth == 2 {
+			return &pgconn.PgError{Code: "40001"}
+		}
+
+		return nil
+	}
+
+	tr := transactor.New(
+		fake,
+		transactor.WithClock(clock.NewFake(time.Now())),
+		transactor.WithRetryPolicy(transactor.RetryPolicy{MaxAttempts: 3}),
+	)
+
+	var outer, inner int
+
+	err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+		outer++
+
+		err := tr.WithTx(ctx, func(context.Context) error {
+			inner++
+			return nil
+		})
+
+		var pgErr *pgconn.PgError
+
+		// only the outermost transaction is retried
+		require.ErrorAs(t, err, &pgErr)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, outer)
+	assert.Equal(t, 1, inner)
+}
+
+func TestRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	fake := transactortest.New()
+	failCommits(fake, 1, "40001")
+
+	tr := transactor.New(
+		fake,
+		transactor.WithClock(clk),
+		transactor.WithRetryPolicy(transactor.RetryPolicy{
+			MaxAttempts: 2,
+			BaseDelay:   time.Second,
+			MaxDelay:    time.Second,
+		}),
+	)
+
+	var attempts atomic.Int64
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- tr.WithTx(context.Background(), func(context.Context) error {
+			attempts.Add(1)
+			return nil
+		})
+	}()
+
+	// the jittered delay is at most MaxDelay
+	require.Eventually(
+		t,
+		func() bool {
+			clk.Advance(time.Second)
+			return len(done) > 0
+		},
+		5*time.Second,
+		time.Millisecond,
+	)
+
+	require.NoError(t, <-done)
+	assert.Equal(t, int64(2), attempts.Load())
+}
+
+func TestRetryCanceledDuringDelay(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	fake := transactortest.New()
+	failCommits(fake, 1, "40001")
+
+	tr := transactor.New(
+		fake,
+		transactor.WithClock(clk),
+		transactor.WithRetryPolicy(transactor.RetryPolicy{
+			MaxAttempts: 2,
+			BaseDelay:   time.Hour,
+			MaxDelay:    time.Hour,
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- tr.WithTx(ctx, func(context.Context) error {
+			return nil
+		})
+	}()
+
+	clk.BlockUntil(1)
+	cancel()
+
+	err := <-done
+
+	var pgErr *pgconn.PgError
+
+	require.ErrorAs(t, err, &pgErr)
+	require.ErrorIs(t, err, context.Canceled)
+}
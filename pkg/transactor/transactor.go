@@ -3,7 +3,9 @@ package transactor
 import (
 	"context"
 	stdErrors "errors"
+	"runtime/debug"
 
+	"github.com/bogi-lyceya-44/common/pkg/clock"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -13,14 +15,98 @@ import (
 // Tx key in context
 type txInjector struct{}
 
+// Transaction state stored in context
+type txState struct {
+	tx pgx.Tx
+	// outermost transaction of the connection,
+	// points to itself for the outermost one
+	root *txState
+	// attempt of the outermost transaction
+	attempt int
+	// callbacks of the outermost transaction
+	hooks *txHooks
+}
+
+// Propagation defines how a transactional function
+// relates to a transaction that already exists in context.
+type Propagation int
+
+const (
+	// Run in a savepoint of the existing transaction,
+	// so the failure of fn rolls back only its own changes.
+	// Start a new transaction if there is none.
+	PropagationNested Propagation = iota
+	// Join the existing transaction without a savepoint.
+	// Start a new transaction if there is none.
+	PropagationRequired
+	// Always start a new transaction on a separate connection.
+	// The existing transaction is not visible to fn.
+	PropagationRequiresNew
+	// Join the existing transaction.
+	// Fail with ErrNoTransaction if there is none.
+	PropagationMandatory
+	// Run without a transaction.
+	// Fail with ErrTransactionExists if there is one.
+	PropagationNever
+	// Join the existing transaction if there is one,
+	// otherwise run without a transaction.
+	PropagationSupports
+)
+
+// Common interface of pools and transactions
 type Querier interface {
 	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
 	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	CopyFrom(
+		ctx context.Context,
+		tableName pgx.Identifier,
+		columnNames []string,
+		rowSrc pgx.CopyFromSource,
+	) (int64, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// Database the transactor works with
+// Implemented by *pgxpool.Pool, wrappers of it and transactortest.Fake
+// Large objects of the transactions of transactortest.Fake panic on use,
+// pgx does not allow to make them on top of another pgx.Tx
+type Beginner interface {
+	Querier
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
+// Database that gives out dedicated connections
+// Needed for session-scoped advisory locks, implemented by *pgxpool.Pool
+type Acquirer interface {
+	Acquire(ctx context.Context) (*pgxpool.Conn, error)
 }
 
 type Transactor struct {
-	db *pgxpool.Pool
+	db           Beginner
+	retry        *RetryPolicy
+	panicAsError bool
+	replicas     *replicaSet
+	clock        clock.Clock
+}
+
+type Option func(*Transactor)
+
+// Set the clock used for retry delays, replica health checks
+// and electors created by the transactor
+// Default is clock.Real()
+func WithClock(c clock.Clock) Option {
+	return func(t *Transactor) {
+		t.clock = c
+	}
+}
+
+// Return *PanicError from WithTx instead of re-panicking
+// when fn panics. The transaction is rolled back either way
+func WithPanicAsError() Option {
+	return func(t *Transactor) {
+		t.panicAsError = true
+	}
 }
 
 // Do fn in transaction with default options
@@ -32,67 +118,225 @@ func (i Transactor) WithTx(
 }
 
 // Do fn in transaction with custom options
+// If transaction is already in context, fn runs in a savepoint
 func (i Transactor) WithTxOpts(
 	ctx context.Context,
 	fn func(context.Context) error,
 	opts pgx.TxOptions,
-) (txErr error) {
-	ctxWithTx, tx, err := injectTx(ctx, i.db, opts)
-	if err != nil {
-		return pkgErrors.Wrap(err, "inject tx")
-	}
+) error {
+	return i.WithTxPropagation(ctx, fn, opts, PropagationNested)
+}
 
-	defer func() {
-		if txErr != nil {
-			txErr = stdErrors.Join(
-				txErr,
-				pkgErrors.Wrap(tx.Rollback(ctxWithTx), "rollback tx"),
-			)
+// Do fn according to propagation mode
+// Options are applied only when a new transaction is started
+func (t Transactor) WithTxPropagation(
+	ctx context.Context,
+	fn func(context.Context) error,
+	opts pgx.TxOptions,
+	propagation Propagation,
+) error {
+	state, inTx := ctx.Value(txInjector{}).(*txState)
+
+	switch propagation {
+	case PropagationNested:
+		if inTx {
+			return t.withSavepoint(ctx, state, fn)
 		}
-	}()
 
-	err = fn(ctxWithTx)
-	if err != nil {
-		return pkgErrors.Wrap(err, "tx function")
-	}
+		return t.withNewTx(ctx, fn, opts)
+	case PropagationRequired:
+		if inTx {
+			return fn(ctx)
+		}
 
-	return pkgErrors.Wrap(tx.Commit(ctxWithTx), "commit tx")
+		return t.withNewTx(ctx, fn, opts)
+	case PropagationRequiresNew:
+		return t.withNewTx(ctx, fn, opts)
+	case PropagationMandatory:
+		if !inTx {
+			return ErrNoTransaction
+		}
+
+		return fn(ctx)
+	case PropagationNever:
+		if inTx {
+			return ErrTransactionExists
+		}
+
+		return fn(ctx)
+	case PropagationSupports:
+		return fn(ctx)
+	default:
+		return pkgErrors.Errorf("unknown propagation %d", propagation)
+	}
 }
 
 // Extracting transaction from context
-// If transaction not found will return Pool
+// If transaction not found will return Pool, with replicas plain SELECTs
+// go to a replica unless the context is made with ForcePrimary
 // ExtractTx returns the query interface
 func (t Transactor) ExtractTx(ctx context.Context) Querier {
-	tx, ok := ctx.Value(txInjector{}).(pgx.Tx)
-	if !ok {
+	state, ok := ctx.Value(txInjector{}).(*txState)
+	if ok {
+		return state.tx
+	}
+
+	if isPrimaryForced(ctx) {
 		return t.db
 	}
 
-	return tx
+	if replica := t.replicas.pick(); replica != nil {
+		return routedQuerier{
+			primary: t.db,
+			replica: replica,
+		}
+	}
+
+	return t.db
 }
 
-func New(db *pgxpool.Pool) *Transactor {
-	return &Transactor{
-		db: db,
+// Extracting large objects of transaction from context
+// Large objects can only be used in a transaction,
+// so returns ErrNoTransaction if there is none
+func (t Transactor) LargeObjects(ctx context.Context) (pgx.LargeObjects, error) {
+	state, ok := ctx.Value(txInjector{}).(*txState)
+	if !ok {
+		return pgx.LargeObjects{}, ErrNoTransaction
 	}
+
+	return state.tx.LargeObjects(), nil
 }
 
-// Injecting transaction into context
-// If transaction is alreay in context, don't create a new one
-func injectTx(
+func New(db Beginner, opts ...Option) *Transactor {
+	t := &Transactor{
+		db:    db,
+		clock: clock.Real(),
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// Starting a new outermost transaction
+// Existing transaction in context is hidden from fn
+// The transaction is retried according to retry policy
+func (t Transactor) withNewTx(
 	ctx context.Context,
-	db *pgxpool.Pool,
+	fn func(context.Context) error,
 	opts pgx.TxOptions,
-) (context.Context, pgx.Tx, error) {
-	// try to extract transaction if exists
-	if tx, ok := ctx.Value(txInjector{}).(pgx.Tx); ok {
-		return ctx, tx, nil
+) error {
+	return t.withRetry(ctx, func(attempt int) error {
+		// read-only transactions go to a replica
+		tx, err := t.poolFor(ctx, opts).BeginTx(ctx, opts)
+		if err != nil {
+			return pkgErrors.Wrap(err, "begin tx")
+		}
+
+		state := &txState{
+			tx:      tx,
+			attempt: attempt,
+			hooks:   &txHooks{},
+		}
+		state.root = state
+
+		// hooks run after the connection is released,
+		// also when fn panics and the panic is propagated
+		committed := false
+		defer func() {
+			state.hooks.finish(ctx, committed)
+		}()
+
+		err = t.runInTx(injectTx(ctx, state), tx, fn)
+		committed = err == nil
+
+		return err
+	})
+}
+
+// Creating a savepoint in the existing transaction
+func (t Transactor) withSavepoint(
+	ctx context.Context,
+	parent *txState,
+	fn func(context.Context) error,
+) error {
+	// pgx implements nested transactions with savepoints
+	sp, err := parent.tx.Begin(ctx)
+	if err != nil {
+		return pkgErrors.Wrap(err, "create savepoint")
+	}
+
+	state := &txState{
+		tx:    sp,
+		root:  parent.root,
+		hooks: parent.hooks,
 	}
 
-	tx, err := db.BeginTx(ctx, opts)
+	mark := state.hooks.mark()
+
+	// deferred, so hooks of a savepoint rolled back by a panic are dropped too
+	released := false
+	defer func() {
+		if !released {
+			state.hooks.rollbackTo(ctx, mark)
+		}
+	}()
+
+	err = t.runInTx(injectTx(ctx, state), sp, fn)
+	released = err == nil
+
+	return err
+}
+
+// Injecting transaction into context
+func injectTx(ctx context.Context, state *txState) context.Context {
+	return context.WithValue(ctx, txInjector{}, state)
+}
+
+// Running fn and committing tx (releasing savepoint)
+// If fn fails or panics, rolling tx (savepoint) back
+func (t Transactor) runInTx(
+	ctxWithTx context.Context,
+	tx pgx.Tx,
+	fn func(context.Context) error,
+) (txErr error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		// the connection must not return to the pool mid-transaction
+		rollbackErr := tx.Rollback(ctxWithTx)
+
+		if !t.panicAsError {
+			panic(r)
+		}
+
+		txErr = stdErrors.Join(
+			&PanicError{
+				Value: r,
+				Stack: debug.Stack(),
+			},
+			pkgErrors.Wrap(rollbackErr, "rollback tx"),
+		)
+	}()
+
+	defer func() {
+		if txErr != nil {
+			txErr = stdErrors.Join(
+				txErr,
+				pkgErrors.Wrap(tx.Rollback(ctxWithTx), "rollback tx"),
+			)
+		}
+	}()
+
+	err := fn(ctxWithTx)
 	if err != nil {
-		return nil, nil, pkgErrors.Wrap(err, "begin tx")
+		return pkgErrors.Wrap(err, "tx function")
 	}
 
-	return context.WithValue(ctx, txInjector{}, tx), tx, nil
+	return pkgErrors.Wrap(tx.Commit(ctxWithTx), "commit tx")
 }
@@ -0,0 +1,288 @@
+package transactor_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bogi-lyceya-44/common/internal/pgtest"
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/bogi-lyceya-44/common/pkg/transactor/transactortest"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTable creates a table unique for the test and returns its name.
+func createTable(t *testing.T, pool *pgxpool.Pool) string {
+	t.Helper()
+
+	name := "test_" + strings.ToLower(strings.NewReplacer("/", "_", " ", "_").Replace(t.Name()))
+
+	_, err := pool.Exec(
+		context.Background(),
+		fmt.Sprintf("DROP TABLE IF EXISTS %[1]s; CREATE TABLE %[1]s (id int PRIMARY KEY)", name),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS "+name)
+	})
+
+	return name
+}
+
+func countRows(t *testing.T, pool *pgxpool.Pool, table string) int {
+	t.Helper()
+
+	var count int
+
+	err := pool.QueryRow(context.Background(), "SELECT count(*) FROM "+table).Scan(&count)
+	require.NoError(t, err)
+
+	return count
+}
+
+func insert(ctx context.Context, tr *transactor.Transactor, table string, id int) error {
+	_, err := tr.ExtractTx(ctx).Exec(ctx, "INSERT INTO "+table+" (id) VALUES ($1)", id)
+	return err
+}
+
+func TestPanicRollsBack(t *testing.T) {
+	t.Parallel()
+
+	pool := pgtest.Connect(t)
+	table := createTable(t, pool)
+	tr := transactor.New(pool)
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = tr.WithTx(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, insert(ctx, tr, table, 1))
+			panic("boom")
+		})
+	})
+
+	assert.Equal(t, 0, countRows(t, pool, table))
+
+	// the connection is back in the pool and not in a transaction
+	assert.Equal(t, int32(0), pool.Stat().AcquiredConns())
+}
+
+func TestPanicAsError(t *testing.T) {
+	t.Parallel()
+
+	pool := pgtest.Connect(t)
+	table := createTable(t, pool)
+	tr := transactor.New(pool, transactor.WithPanicAsError())
+
+	err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+		require.NoError(t, insert(ctx, tr, table, 1))
+		panic("boom")
+	})
+
+	var panicErr *transactor.PanicError
+
+	require.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, "boom", panicErr.Value)
+	assert.NotEmpty(t, panicErr.Stack)
+	assert.Equal(t, 0, countRows(t, pool, table))
+}
+
+func TestPanicInSavepoint(t *testing.T) {
+	t.Parallel()
+
+	pool := pgtest.Connect(t)
+	table := createTable(t, pool)
+	tr := transactor.New(pool)
+
+	assert.Panics(t, func() {
+		_ = tr.WithTx(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, insert(ctx, tr, table, 1))
+
+			return tr.WithTx(ctx, func(ctx context.Context) error {
+				require.NoError(t, insert(ctx, tr, table, 2))
+				panic("boom")
+			})
+		})
+	})
+
+	assert.Equal(t, 0, countRows(t, pool, table))
+	assert.Equal(t, int32(0), pool.Stat().AcquiredConns())
+}
+
+func TestNestedSavepoint(t *testing.T) {
+	t.Parallel()
+
+	pool := pgtest.Connect(t)
+	table := createTable(t, pool)
+	tr := transactor.New(pool)
+
+	errInner := errors.New("inner")
+
+	err := tr.WithTx(context.Background(), func(ctx context.Context) error {
+		require.NoError(t, insert(ctx, tr, table, 1))
+
+		err := tr.WithTx(ctx, func(ctx context.Context) error {
+			require.NoError(t, insert(ctx, tr, table, 2))
+			return errInner
+		})
+		require.ErrorIs(t, err, errInner)
+
+		// the outer transaction is still usable
+		return insert(ctx, tr, table, 3)
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, countRows(t, pool, table))
+}
+
+// describe formats events of the fake as "kind depth" for comparison.
+func describe(events []transactortest.Event) []string {
+	described := make([]string, 0, len(events))
+
+	for _, e := range events {
+		described = append(described, fmt.Sprintf("%s %d", e.Kind, e.Depth))
+	}
+
+	return described
+}
+
+func TestPropagation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		propagation transactor.Propagation
+		ambient     bool
+		err         error
+		expected    []string
+	}{
+		{
+			name:        "nested starts transaction",
+			propagation: transactor.PropagationNested,
+			expected:    []string{"begin 1", "exec 1", "commit 1"},
+		},
+		{
+			name:        "nested makes savepoint",
+			propagation: transactor.PropagationNested,
+			ambient:     true,
+			expected:    []string{"begin 1", "begin 2", "exec 2", "commit 2", "commit 1"},
+		},
+		{
+			name:        "required starts transaction",
+			propagation: transactor.PropagationRequired,
+			expected:    []string{"begin 1", "exec 1", "commit 1"},
+		},
+		{
+			name:        "required joins transaction",
+			propagation: transactor.PropagationRequired,
+			ambient:     true,
+			expected:    []string{"begin 1", "exec 1", "commit 1"},
+		},
+		{
+			name:        "requires new starts transaction",
+			propagation: transactor.PropagationRequiresNew,
+			expected:    []string{"begin 1", "exec 1", "commit 1"},
+		},
+		{
+			name:        "requires new starts separate transaction",
+			propagation: transactor.PropagationRequiresNew,
+			ambient:     true,
+			expected:    []string{"begin 1", "begin 1", "exec 1", "commit 1", "commit 1"},
+		},
+		{
+			name:        "mandatory without transaction",
+			propagation: transactor.PropagationMandatory,
+			err:         transactor.ErrNoTransaction,
+			expected:    []string{},
+		},
+		{
+			name:        "mandatory joins transaction",
+			propagation: transactor.PropagationMandatory,
+			ambient:     true,
+			expected:    []string{"begin 1", "exec 1", "commit 1"},
+		},
+		{
+			name:        "never runs without transaction",
+			propagation: transactor.PropagationNever,
+			expected:    []string{"exec 0"},
+		},
+		{
+			name:        "never with transaction",
+			propagation: transactor.PropagationNever,
+			ambient:     true,
+			err:         transactor.ErrTransactionExists,
+			expected:    []string{"begin 1", "commit 1"},
+		},
+		{
+			name:        "supports runs without transaction",
+			propagation: transactor.PropagationSupports,
+			expected:    []string{"exec 0"},
+		},
+		{
+			name:        "supports joins transaction",
+			propagation: transactor.PropagationSupports,
+			ambient:     true,
+			expected:    []string{"begin 1", "exec 1", "commit 1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			fake := transactortest.New()
+			tr := transactor.New(fake)
+
+			run := func(ctx context.Context) error {
+				return tr.WithTxPropagation(
+					ctx,
+					func(ctx context.Context) error {
+						_, err := tr.ExtractTx(ctx).Exec(ctx, "fn")
+						return err
+					},
+					pgx.TxOptions{},
+					tc.propagation,
+				)
+			}
+
+			var err error
+
+			if tc.ambient {
+				_ = tr.WithTx(context.Background(), func(ctx context.Context) error {
+					err = run(ctx)
+					return nil
+				})
+			} else {
+				err = run(context.Background())
+			}
+
+			if tc.err != nil {
+				require.ErrorIs(st, err, tc.err)
+			} else {
+				require.NoError(st, err)
+			}
+
+			assert.Equal(st, tc.expected, describe(fake.Events()))
+		})
+	}
+}
+
+func TestUnknownPropagation(t *testing.T) {
+	t.Parallel()
+
+	fake := transactortest.New()
+	tr := transactor.New(fake)
+
+	err := tr.WithTxPropagation(
+		context.Background(),
+		func(context.Context) error { return nil },
+		pgx.TxOptions{},
+		transactor.Propagation(100),
+	)
+	require.Error(t, err)
+	assert.Empty(t, fake.Events())
+}
@@ -0,0 +1,92 @@
+package transactortest
+
+import (
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+)
+
+// batchResults implements pgx.BatchResults,
+// results of the queued queries are given by Fake.OnExec and Fake.OnQuery.
+type batchResults struct {
+	fake  *Fake
+	batch *pgx.Batch
+	// next is the index of the queued query whose result is read next
+	next   int
+	err    error
+	closed bool
+}
+
+func (br *batchResults) Exec() (pgconn.CommandTag, error) {
+	qq, err := br.take()
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+
+	if br.fake.OnExec == nil {
+		return pgconn.CommandTag{}, nil
+	}
+
+	return br.fake.OnExec(qq.SQL, qq.Arguments)
+}
+
+func (br *batchResults) Query() (pgx.Rows, error) {
+	qq, err := br.take()
+	if err != nil {
+		return &rows{err: err}, err
+	}
+
+	return br.fake.results(qq.SQL, qq.Arguments)
+}
+
+func (br *batchResults) QueryRow() pgx.Row {
+	r, err := br.Query()
+
+	return &row{rows: r, err: err}
+}
+
+// Close reads the remaining results calling their callbacks, as pgx does.
+func (br *batchResults) Close() error {
+	if br.err != nil || br.closed {
+		return br.err
+	}
+
+	for br.err == nil && br.next < len(br.batch.QueuedQueries) {
+		qq := br.batch.QueuedQueries[br.next]
+
+		var err error
+
+		if qq.Fn != nil {
+			err = qq.Fn(br)
+		} else {
+			_, err = br.Exec()
+		}
+
+		if err != nil {
+			br.err = err
+		}
+	}
+
+	br.closed = true
+
+	return br.err
+}
+
+func (br *batchResults) take() (*pgx.QueuedQuery, error) {
+	if br.err != nil {
+		return nil, br.err
+	}
+
+	if br.closed {
+		return nil, errors.New("batch already closed")
+	}
+
+	if br.next >= len(br.batch.QueuedQueries) {
+		return nil, errors.New("no more results in batch")
+	}
+
+	qq := br.batch.QueuedQueries[br.next]
+	br.next++
+
+	return qq, nil
+}
@@ -0,0 +1,355 @@
+// Package transactortest provides an in-memory database for tests
+// of code built on transactor.Transactor.
+//
+// Large objects are not emulated. Transactions return zero pgx.LargeObjects,
+// which panic on first use, since pgx offers no way to build them
+// on top of a fake transaction.
+package transactortest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+)
+
+// ErrNotSupported is returned by methods of transactions
+// the fake does not emulate.
+var ErrNotSupported = errors.New("not supported by fake")
+
+// EventKind is a kind of a recorded event.
+type EventKind int
+
+const (
+	EventBegin EventKind = iota
+	EventCommit
+	EventRollback
+	EventExec
+	EventQuery
+	EventCopyFrom
+	// EventBatch is recorded for every query of a sent batch.
+	EventBatch
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventBegin:
+		return "begin"
+	case EventCommit:
+		return "commit"
+	case EventRollback:
+		return "rollback"
+	case EventExec:
+		return "exec"
+	case EventQuery:
+		return "query"
+	case EventCopyFrom:
+		return "copy from"
+	case EventBatch:
+		return "batch"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a recorded call.
+type Event struct {
+	Kind EventKind
+	// Depth is 0 outside of transactions, 1 in a transaction
+	// and greater in savepoints. For Begin it is the depth of the new one.
+	Depth int
+	// Opts are the options of an outermost Begin.
+	Opts pgx.TxOptions
+	// SQL is the statement, or the sanitized table name for CopyFrom.
+	SQL  string
+	Args []any
+	// Columns and Rows are the data passed to CopyFrom.
+	Columns []string
+	Rows    [][]any
+}
+
+// Result is the result of a query.
+type Result struct {
+	// Columns are the names of the columns,
+	// needed only to scan rows into structs by name.
+	Columns []string
+	// Rows are values assignable or convertible to the scan targets.
+	Rows [][]any
+}
+
+// Fake is an in-memory transactor.Beginner.
+// It does not execute SQL: it records every call,
+// and results are given by OnExec and OnQuery.
+// Fake is safe for concurrent use.
+type Fake struct {
+	// OnExec returns the result of Exec. Default returns an empty tag.
+	OnExec func(sql string, args []any) (pgconn.CommandTag, error)
+	// OnQuery returns the result of Query and QueryRow. Default returns no rows.
+	OnQuery func(sql string, args []any) (Result, error)
+	// OnCommit fails the commit of a transaction or a savepoint.
+	OnCommit func(depth int) error
+
+	mu     *sync.Mutex
+	events []Event
+}
+
+var _ transactor.Beginner = (*Fake)(nil)
+
+// New creates a fake. The zero Fake is not usable.
+func New() *Fake {
+	return &Fake{
+		mu: &sync.Mutex{},
+	}
+}
+
+// Events returns the recorded events in call order.
+func (f *Fake) Events() []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Event(nil), f.events...)
+}
+
+// SQL returns the statements passed to Exec, Query, QueryRow
+// and sent in batches in call order.
+func (f *Fake) SQL() []string {
+	var statements []string
+
+	for _, e := range f.Events() {
+		if e.Kind == EventExec || e.Kind == EventQuery || e.Kind == EventBatch {
+			statements = append(statements, e.SQL)
+		}
+	}
+
+	return statements
+}
+
+// Reset forgets the recorded events.
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = nil
+}
+
+func (f *Fake) BeginTx(_ context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	f.record(Event{Kind: EventBegin, Depth: 1, Opts: opts})
+
+	return &fakeTx{fake: f, depth: 1}, nil
+}
+
+func (f *Fake) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return f.exec(0, sql, args)
+}
+
+func (f *Fake) Query(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return f.query(0, sql, args)
+}
+
+func (f *Fake) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	return f.queryRow(0, sql, args)
+}
+
+func (f *Fake) CopyFrom(
+	_ context.Context,
+	tableName pgx.Identifier,
+	columnNames []string,
+	rowSrc pgx.CopyFromSource,
+) (int64, error) {
+	return f.copyFrom(0, tableName, columnNames, rowSrc)
+}
+
+func (f *Fake) SendBatch(_ context.Context, b *pgx.Batch) pgx.BatchResults {
+	return f.sendBatch(0, b)
+}
+
+func (f *Fake) record(e Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = append(f.events, e)
+}
+
+func (f *Fake) exec(depth int, sql string, args []any) (pgconn.CommandTag, error) {
+	f.record(Event{Kind: EventExec, Depth: depth, SQL: sql, Args: args})
+
+	if f.OnExec == nil {
+		return pgconn.CommandTag{}, nil
+	}
+
+	return f.OnExec(sql, args)
+}
+
+func (f *Fake) query(depth int, sql string, args []any) (pgx.Rows, error) {
+	f.record(Event{Kind: EventQuery, Depth: depth, SQL: sql, Args: args})
+
+	return f.results(sql, args)
+}
+
+func (f *Fake) queryRow(depth int, sql string, args []any) pgx.Row {
+	r, err := f.query(depth, sql, args)
+
+	return &row{rows: r, err: err}
+}
+
+// results returns the rows given by OnQuery without recording the call.
+func (f *Fake) results(sql string, args []any) (pgx.Rows, error) {
+	if f.OnQuery == nil {
+		return &rows{}, nil
+	}
+
+	result, err := f.OnQuery(sql, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rows{columns: result.Columns, values: result.Rows}, nil
+}
+
+func (f *Fake) copyFrom(
+	depth int,
+	tableName pgx.Identifier,
+	columnNames []string,
+	rowSrc pgx.CopyFromSource,
+) (int64, error) {
+	var copied [][]any
+
+	for rowSrc.Next() {
+		values, err := rowSrc.Values()
+		if err != nil {
+			return 0, err
+		}
+
+		copied = append(copied, values)
+	}
+
+	if err := rowSrc.Err(); err != nil {
+		return 0, err
+	}
+
+	f.record(Event{
+		Kind:    EventCopyFrom,
+		Depth:   depth,
+		SQL:     tableName.Sanitize(),
+		Columns: columnNames,
+		Rows:    copied,
+	})
+
+	return int64(len(copied)), nil
+}
+
+func (f *Fake) sendBatch(depth int, b *pgx.Batch) pgx.BatchResults {
+	for _, qq := range b.QueuedQueries {
+		f.record(Event{Kind: EventBatch, Depth: depth, SQL: qq.SQL, Args: qq.Arguments})
+	}
+
+	return &batchResults{fake: f, batch: b}
+}
+
+// fakeTx is a transaction or a savepoint of Fake.
+type fakeTx struct {
+	fake   *Fake
+	depth  int
+	closed bool
+}
+
+func (tx *fakeTx) Begin(context.Context) (pgx.Tx, error) {
+	if tx.closed {
+		return nil, pgx.ErrTxClosed
+	}
+
+	tx.fake.record(Event{Kind: EventBegin, Depth: tx.depth + 1})
+
+	return &fakeTx{fake: tx.fake, depth: tx.depth + 1}, nil
+}
+
+func (tx *fakeTx) Commit(context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+
+	if tx.fake.OnCommit != nil {
+		if err := tx.fake.OnCommit(tx.depth); err != nil {
+			// the transaction stays open, so it is rolled back by the caller
+			return err
+		}
+	}
+
+	tx.closed = true
+	tx.fake.record(Event{Kind: EventCommit, Depth: tx.depth})
+
+	return nil
+}
+
+func (tx *fakeTx) Rollback(context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+
+	tx.closed = true
+	tx.fake.record(Event{Kind: EventRollback, Depth: tx.depth})
+
+	return nil
+}
+
+func (tx *fakeTx) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if tx.closed {
+		return pgconn.CommandTag{}, pgx.ErrTxClosed
+	}
+
+	return tx.fake.exec(tx.depth, sql, args)
+}
+
+func (tx *fakeTx) Query(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if tx.closed {
+		return nil, pgx.ErrTxClosed
+	}
+
+	return tx.fake.query(tx.depth, sql, args)
+}
+
+func (tx *fakeTx) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	if tx.closed {
+		return &row{err: pgx.ErrTxClosed}
+	}
+
+	return tx.fake.queryRow(tx.depth, sql, args)
+}
+
+func (tx *fakeTx) CopyFrom(
+	_ context.Context,
+	tableName pgx.Identifier,
+	columnNames []string,
+	rowSrc pgx.CopyFromSource,
+) (int64, error) {
+	if tx.closed {
+		return 0, pgx.ErrTxClosed
+	}
+
+	return tx.fake.copyFrom(tx.depth, tableName, columnNames, rowSrc)
+}
+
+func (tx *fakeTx) SendBatch(_ context.Context, b *pgx.Batch) pgx.BatchResults {
+	if tx.closed {
+		return &batchResults{err: pgx.ErrTxClosed}
+	}
+
+	return tx.fake.sendBatch(tx.depth, b)
+}
+
+// LargeObjects returns zero large objects, which panic on use,
+// the fake does not store them.
+func (tx *fakeTx) LargeObjects() pgx.LargeObjects {
+	return pgx.LargeObjects{}
+}
+
+func (tx *fakeTx) Prepare(context.Context, string, string) (*pgconn.StatementDescription, error) {
+	return nil, ErrNotSupported
+}
+
+func (tx *fakeTx) Conn() *pgx.Conn {
+	return nil
+}
@@ -0,0 +1,281 @@
+package transactortest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bogi-lyceya-44/common/pkg/transactor"
+	"github.com/bogi-lyceya-44/common/pkg/transactor/transactortest"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type step struct {
+	kind  transactortest.EventKind
+	depth int
+	sql   string
+}
+
+func steps(events []transactortest.Event) []step {
+	result := make([]step, 0, len(events))
+
+	for _, e := range events {
+		result = append(result, step{kind: e.Kind, depth: e.Depth, sql: e.SQL})
+	}
+
+	return result
+}
+
+func TestTransactionBoundaries(t *testing.T) {
+	t.Parallel()
+
+	errFn := errors.New("fn")
+
+	testCases := []struct {
+		name     string
+		fn       func(ctx context.Context, tr *transactor.Transactor) error
+		expected []step
+	}{
+		{
+			name: "outside of transaction",
+			fn: func(ctx context.Context, tr *transactor.Transactor) error {
+				_, err := tr.ExtractTx(ctx).Exec(ctx, "DELETE FROM users")
+				return err
+			},
+			expected: []step{
+				{kind: transactortest.EventExec, sql: "DELETE FROM users"},
+			},
+		},
+		{
+			name: "commit",
+			fn: func(ctx context.Context, tr *transactor.Transactor) error {
+				return tr.WithTx(ctx, func(ctx context.Context) error {
+					_, err := tr.ExtractTx(ctx).Exec(ctx, "DELETE FROM users")
+					return err
+				})
+			},
+			expected: []step{
+				{kind: transactortest.EventBegin, depth: 1},
+				{kind: transactortest.EventExec, depth: 1, sql: "DELETE FROM users"},
+				{kind: transactortest.EventCommit, depth: 1},
+			},
+		},
+		{
+			name: "rolled back savepoint",
+			fn: func(ctx context.Context, tr *transactor.Transactor) error {
+				return tr.WithTx(ctx, func(ctx context.Context) error {
+					err := tr.WithTx(ctx, func(ctx context.Context) error {
+						_, err := tr.ExtractTx(ctx).Exec(ctx, "DELETE FROM users")
+						require.NoError(t, err)
+
+						return errFn
+					})
+					require.ErrorIs(t, err, errFn)
+
+					return nil
+				})
+			},
+			expected: []step{
+				{kind: transactortest.EventBegin, depth: 1},
+				{kind: transactortest.EventBegin, depth: 2},
+				{kind: transactortest.EventExec, depth: 2, sql: "DELETE FROM users"},
+				{kind: transactortest.EventRollback, depth: 2},
+				{kind: transactortest.EventCommit, depth: 1},
+			},
+		},
+		{
+			name: "required joins",
+			fn: func(ctx context.Context, tr *transactor.Transactor) error {
+				return tr.WithTx(ctx, func(ctx context.Context) error {
+					return tr.WithTxPropagation(ctx, func(context.Context) error {
+						return errFn
+					}, pgx.TxOptions{}, transactor.PropagationRequired)
+				})
+			},
+			expected: []step{
+				{kind: transactortest.EventBegin, depth: 1},
+				{kind: transactortest.EventRollback, depth: 1},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			fake := transactortest.New()
+			tr := transactor.New(fake)
+
+			_ = tc.fn(context.Background(), tr)
+
+			assert.Equal(st, tc.expected, steps(fake.Events()))
+		})
+	}
+}
+
+func TestFailedCommit(t *testing.T) {
+	t.Parallel()
+
+	errCommit := errors.New("commit")
+
+	fake := transactortest.New()
+	fake.OnCommit = func(int) error {
+		return errCommit
+	}
+
+	tr := transactor.New(fake)
+
+	err := tr.WithTx(context.Background(), func(context.Context) error {
+		return nil
+	})
+	require.ErrorIs(t, err, errCommit)
+
+	assert.Equal(t, []step{
+		{kind: transactortest.EventBegin, depth: 1},
+		{kind: transactortest.EventRollback, depth: 1},
+	}, steps(fake.Events()))
+}
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+
+	fake := transactortest.New()
+	fake.OnQuery = func(_ string, args []any) (transactortest.Result, error) {
+		if args[0] == 1 {
+			return transactortest.Result{Rows: [][]any{{"alice", int32(30), nil}}}, nil
+		}
+
+		return transactortest.Result{}, nil
+	}
+
+	tr := transactor.New(fake)
+	ctx := context.Background()
+
+	var (
+		name  string
+		age   int
+		email *string
+	)
+
+	err := tr.ExtractTx(ctx).
+		QueryRow(ctx, "SELECT name, age, email FROM users WHERE id = $1", 1).
+		Scan(&name, &age, &email)
+	require.NoError(t, err)
+
+	assert.Equal(t, "alice", name)
+	assert.Equal(t, 30, age)
+	assert.Nil(t, email)
+
+	err = tr.ExtractTx(ctx).
+		QueryRow(ctx, "SELECT name, age, email FROM users WHERE id = $1", 2).
+		Scan(&name, &age, &email)
+	require.ErrorIs(t, err, pgx.ErrNoRows)
+
+	assert.Equal(t, []string{
+		"SELECT name, age, email FROM users WHERE id = $1",
+		"SELECT name, age, email FROM users WHERE id = $1",
+	}, fake.SQL())
+}
+
+func TestBulkInsideAndOutsideTx(t *testing.T) {
+	t.Parallel()
+
+	// bulk code written once against Querier
+	ingest := func(ctx context.Context, q transactor.Querier) error {
+		_, err := q.CopyFrom(
+			ctx,
+			pgx.Identifier{"users"},
+			[]string{"name"},
+			pgx.CopyFromRows([][]any{{"alice"}, {"bob"}}),
+		)
+		if err != nil {
+			return err
+		}
+
+		batch := &pgx.Batch{}
+		batch.Queue("UPDATE stats SET users = users + $1", 2)
+
+		return q.SendBatch(ctx, batch).Close()
+	}
+
+	testCases := []struct {
+		name  string
+		inTx  bool
+		depth int
+	}{
+		{name: "outside of transaction"},
+		{name: "in transaction", inTx: true, depth: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			fake := transactortest.New()
+			tr := transactor.New(fake)
+
+			run := func(ctx context.Context) error {
+				return ingest(ctx, tr.ExtractTx(ctx))
+			}
+
+			var err error
+			if tc.inTx {
+				err = tr.WithTx(context.Background(), run)
+			} else {
+				err = run(context.Background())
+			}
+
+			require.NoError(st, err)
+
+			var bulk []transactortest.Event
+
+			for _, e := range fake.Events() {
+				if e.Kind == transactortest.EventCopyFrom || e.Kind == transactortest.EventBatch {
+					bulk = append(bulk, e)
+				}
+			}
+
+			assert.Equal(st, []transactortest.Event{
+				{
+					Kind:    transactortest.EventCopyFrom,
+					Depth:   tc.depth,
+					SQL:     `"users"`,
+					Columns: []string{"name"},
+					Rows:    [][]any{{"alice"}, {"bob"}},
+				},
+				{
+					Kind:  transactortest.EventBatch,
+					Depth: tc.depth,
+					SQL:   "UPDATE stats SET users = users + $1",
+					Args:  []any{2},
+				},
+			}, bulk)
+		})
+	}
+}
+
+func TestLargeObjectsRequireTx(t *testing.T) {
+	t.Parallel()
+
+	tr := transactor.New(transactortest.New())
+
+	_, err := tr.LargeObjects(context.Background())
+	require.ErrorIs(t, err, transactor.ErrNoTransaction)
+
+	err = tr.WithTx(context.Background(), func(ctx context.Context) error {
+		lo, err := tr.LargeObjects(ctx)
+		if err != nil {
+			return err
+		}
+
+		// the fake does not emulate large objects
+		assert.Panics(t, func() {
+			_, _ = lo.Create(ctx, 0)
+		})
+
+		return nil
+	})
+	require.NoError(t, err)
+}
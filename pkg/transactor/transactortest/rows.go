@@ -0,0 +1,151 @@
+package transactortest
+
+import (
+	"reflect"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+)
+
+// rows implements pgx.Rows over the result given by Fake.OnQuery.
+type rows struct {
+	columns []string
+	values  [][]any
+	// current is the index of the row read by Scan
+	current int
+	// started is set by the first Next
+	started bool
+	err     error
+}
+
+func (r *rows) Close() {}
+
+func (r *rows) Err() error {
+	return r.err
+}
+
+func (r *rows) CommandTag() pgconn.CommandTag {
+	return pgconn.NewCommandTag("SELECT")
+}
+
+func (r *rows) FieldDescriptions() []pgconn.FieldDescription {
+	fields := make([]pgconn.FieldDescription, 0, len(r.columns))
+	for _, column := range r.columns {
+		fields = append(fields, pgconn.FieldDescription{Name: column})
+	}
+
+	return fields
+}
+
+func (r *rows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	if r.started {
+		r.current++
+	}
+
+	r.started = true
+
+	return r.current < len(r.values)
+}
+
+func (r *rows) Scan(dest ...any) error {
+	if !r.started || r.current >= len(r.values) {
+		return errors.New("scan without a row")
+	}
+
+	values := r.values[r.current]
+	if len(dest) != len(values) {
+		return errors.Errorf("%d targets for %d values", len(dest), len(values))
+	}
+
+	for i, d := range dest {
+		if err := assign(d, values[i]); err != nil {
+			r.err = errors.Wrapf(err, "column %d", i)
+			return r.err
+		}
+	}
+
+	return nil
+}
+
+func (r *rows) Values() ([]any, error) {
+	if !r.started || r.current >= len(r.values) {
+		return nil, errors.New("values without a row")
+	}
+
+	return r.values[r.current], nil
+}
+
+func (r *rows) RawValues() [][]byte {
+	return nil
+}
+
+func (r *rows) Conn() *pgx.Conn {
+	return nil
+}
+
+// assign sets the value to the scan target
+// if the value is assignable or convertible to its type.
+// Nil values set the target to its zero value.
+func assign(dest, value any) error {
+	target := reflect.ValueOf(dest)
+	if target.Kind() != reflect.Pointer || target.IsNil() {
+		return errors.Errorf("target %T is not a non-nil pointer", dest)
+	}
+
+	target = target.Elem()
+
+	if value == nil {
+		target.SetZero()
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch {
+	case v.Type().AssignableTo(target.Type()):
+		target.Set(v)
+	// numbers are convertible to strings as runes, which is never meant
+	case target.Kind() == reflect.String && v.Kind() != reflect.String:
+		return errors.Errorf("can not scan %T into %T", value, dest)
+	case v.Type().ConvertibleTo(target.Type()):
+		target.Set(v.Convert(target.Type()))
+	// nullable columns scanned into pointers
+	case target.Kind() == reflect.Pointer && v.Type().ConvertibleTo(target.Type().Elem()):
+		ptr := reflect.New(target.Type().Elem())
+		ptr.Elem().Set(v.Convert(target.Type().Elem()))
+		target.Set(ptr)
+	default:
+		return errors.Errorf("can not scan %T into %T", value, dest)
+	}
+
+	return nil
+}
+
+// row implements pgx.Row.
+type row struct {
+	rows pgx.Rows
+	err  error
+}
+
+func (r *row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	defer r.rows.Close()
+
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+
+		return pgx.ErrNoRows
+	}
+
+	return r.rows.Scan(dest...)
+}
@@ -0,0 +1,9 @@
+package wal
+
+import "github.com/pkg/errors"
+
+var (
+	ErrClosed         = errors.New("log is closed")
+	ErrUnknownSeq     = errors.New("unknown sequence number")
+	ErrRecordTooLarge = errors.New("record is too large")
+)
@@ -0,0 +1,630 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	stdErrors "errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	segmentExt = ".seg"
+	ackExt     = ".ack"
+
+	// record header: payload length, crc32 of seq and payload, seq
+	headerSize = 4 + 4 + 8
+
+	DefaultSegmentSize  = 64 << 20
+	DefaultSyncInterval = time.Second
+)
+
+// SyncPolicy defines when written data is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways calls fsync after every append and ack.
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodically calls fsync every SyncInterval,
+	// so a crash may lose writes of the last interval.
+	SyncPeriodically
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+type Options struct {
+	// SegmentSize is the size after which a new segment is started.
+	// Default is DefaultSegmentSize.
+	SegmentSize int64
+	Sync        SyncPolicy
+	// SyncInterval is used with SyncPeriodically.
+	// Default is DefaultSyncInterval.
+	SyncInterval time.Duration
+}
+
+// Record is an appended entry that is not acknowledged yet.
+type Record struct {
+	Seq  uint64
+	Data []byte
+}
+
+// Log is a segmented write-ahead log of unacknowledged records.
+//
+// Records are appended to the active segment and get increasing
+// sequence numbers. Acknowledged sequence numbers are appended
+// to the ack file of their segment. A segment is removed
+// as soon as all of its records are acknowledged
+// and it is not the active one anymore.
+//
+// A new active segment is started on every Open,
+// and records of previous runs that were not acknowledged
+// are returned by Unacked.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu       *sync.Mutex
+	closed   bool
+	nextSeq  uint64
+	segments []*segment
+	active   *segment
+	unacked  []Record
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type segment struct {
+	path     string
+	firstSeq uint64
+	lastSeq  uint64
+	size     int64
+	records  int
+	acked    map[uint64]struct{}
+
+	file    *os.File
+	ackFile *os.File
+}
+
+// Open opens or creates the log in dir.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "create dir")
+	}
+
+	l := &Log{
+		dir:     dir,
+		opts:    opts,
+		mu:      &sync.Mutex{},
+		nextSeq: 1,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if err := l.load(); err != nil {
+		l.closeFiles()
+		return nil, errors.Wrap(err, "load segments")
+	}
+
+	if err := l.rotate(); err != nil {
+		l.closeFiles()
+		return nil, errors.Wrap(err, "create segment")
+	}
+
+	if opts.Sync == SyncPeriodically {
+		go l.syncPeriodically()
+	} else {
+		close(l.done)
+	}
+
+	return l, nil
+}
+
+// Unacked returns records of previous runs that were not acknowledged,
+// in the order they were appended.
+func (l *Log) Unacked() []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return slices.Clone(l.unacked)
+}
+
+// Append appends a record and returns its sequence number.
+func (l *Log) Append(data []byte) (uint64, error) {
+	if len(data) > math.MaxUint32 {
+		return 0, ErrRecordTooLarge
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, ErrClosed
+	}
+
+	if l.active.size >= l.opts.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, errors.Wrap(err, "rotate segment")
+		}
+	}
+
+	seq := l.nextSeq
+
+	buf := make([]byte, headerSize+len(data))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint64(buf[8:16], seq)
+	copy(buf[headerSize:], data)
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[8:]))
+
+	if _, err := l.active.file.Write(buf); err != nil {
+		return 0, stdErrors.Join(errors.Wrap(err, "write record"), l.active.truncate())
+	}
+
+	if l.opts.Sync == SyncAlways {
+		if err := l.active.file.Sync(); err != nil {
+			return 0, stdErrors.Join(errors.Wrap(err, "sync segment"), l.active.truncate())
+		}
+	}
+
+	l.nextSeq++
+
+	if l.active.records == 0 {
+		l.active.firstSeq = seq
+	}
+
+	l.active.lastSeq = seq
+	l.active.size += int64(len(buf))
+	l.active.records++
+
+	return seq, nil
+}
+
+// Ack acknowledges records, so they are not returned by Unacked anymore.
+// Acknowledging a record twice is a no-op, including a record
+// whose segment was already removed.
+// If any seq was never appended, nothing is acknowledged.
+func (l *Log) Ack(seqs ...uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	bySegment := make(map[*segment][]uint64)
+
+	for _, seq := range seqs {
+		if seq == 0 || seq >= l.nextSeq {
+			return errors.Wrapf(ErrUnknownSeq, "seq %d", seq)
+		}
+
+		// segments are removed only when all of their records are acknowledged
+		s := l.segmentOf(seq)
+		if s == nil {
+			continue
+		}
+
+		if _, ok := s.acked[seq]; ok || slices.Contains(bySegment[s], seq) {
+			continue
+		}
+
+		bySegment[s] = append(bySegment[s], seq)
+	}
+
+	var err error
+
+	for s, acked := range bySegment {
+		if err = l.writeAcks(s, acked); err != nil {
+			break
+		}
+	}
+
+	// acks written before a failure are kept
+	l.unacked = slices.DeleteFunc(l.unacked, func(r Record) bool {
+		s := l.segmentOf(r.Seq)
+		_, ok := s.acked[r.Seq]
+
+		return ok
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return l.removeAcked()
+}
+
+// writeAcks appends acknowledged seqs to the ack file of the segment
+// and marks them as acknowledged once they are written.
+func (l *Log) writeAcks(s *segment, seqs []uint64) error {
+	buf := make([]byte, 8*len(seqs))
+
+	for i, seq := range seqs {
+		binary.LittleEndian.PutUint64(buf[8*i:], seq)
+	}
+
+	if _, err := s.ackFile.Write(buf); err != nil {
+		return errors.Wrap(err, "write acks")
+	}
+
+	if l.opts.Sync == SyncAlways {
+		if err := s.ackFile.Sync(); err != nil {
+			return errors.Wrap(err, "sync acks")
+		}
+	}
+
+	for _, seq := range seqs {
+		s.acked[seq] = struct{}{}
+	}
+
+	return nil
+}
+
+// Sync flushes the active segment and all ack files to stable storage.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	return l.sync()
+}
+
+// Close syncs and closes the log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+
+	l.closed = true
+	close(l.stop)
+
+	err := l.sync()
+	l.closeFiles()
+	l.mu.Unlock()
+
+	<-l.done
+
+	return err
+}
+
+func (l *Log) syncPeriodically() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			// errors are reported by the next explicit Sync or Close
+			_ = l.Sync()
+		}
+	}
+}
+
+func (l *Log) sync() error {
+	var errs []error
+
+	for _, s := range l.segments {
+		if s == l.active {
+			errs = append(errs, s.file.Sync())
+		}
+
+		errs = append(errs, s.ackFile.Sync())
+	}
+
+	return errors.Wrap(stdErrors.Join(errs...), "sync")
+}
+
+// segmentOf returns the segment containing seq or nil.
+func (l *Log) segmentOf(seq uint64) *segment {
+	i, found := slices.BinarySearchFunc(
+		l.segments,
+		seq,
+		func(s *segment, seq uint64) int {
+			switch {
+			// only the active segment may be empty, and it is the last one
+			case s.records == 0 || seq < s.firstSeq:
+				return 1
+			case seq > s.lastSeq:
+				return -1
+			default:
+				return 0
+			}
+		},
+	)
+	if !found {
+		return nil
+	}
+
+	return l.segments[i]
+}
+
+// removeAcked removes inactive segments with all records acknowledged.
+func (l *Log) removeAcked() error {
+	var errs []error
+
+	l.segments = slices.DeleteFunc(l.segments, func(s *segment) bool {
+		if s == l.active || len(s.acked) < s.records {
+			return false
+		}
+
+		errs = append(errs, s.remove())
+
+		return true
+	})
+
+	return errors.Wrap(stdErrors.Join(errs...), "remove segments")
+}
+
+// rotate starts a new active segment.
+func (l *Log) rotate() error {
+	if l.active != nil {
+		if err := l.active.file.Sync(); err != nil {
+			return errors.Wrap(err, "sync segment")
+		}
+
+		// the segment is read-only from now on
+		if err := l.active.file.Close(); err != nil {
+			return errors.Wrap(err, "close segment")
+		}
+
+		l.active.file = nil
+	}
+
+	s := &segment{
+		path:  filepath.Join(l.dir, fmt.Sprintf("%020d", l.nextSeq)),
+		acked: make(map[uint64]struct{}),
+	}
+
+	var err error
+
+	s.file, err = os.OpenFile(s.path+segmentExt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "open segment")
+	}
+
+	s.ackFile, err = os.OpenFile(s.path+ackExt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		s.file.Close()
+		return errors.Wrap(err, "open ack file")
+	}
+
+	previous := l.active
+	l.active = s
+	l.segments = append(l.segments, s)
+
+	if previous != nil {
+		return l.removeAcked()
+	}
+
+	return nil
+}
+
+// load reads existing segments and collects records that are not acknowledged.
+func (l *Log) load() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return errors.Wrap(err, "read dir")
+	}
+
+	var names []string
+
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), segmentExt)
+		if !ok {
+			continue
+		}
+
+		if _, err := strconv.ParseUint(name, 10, 64); err != nil {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	// names are zero-padded, so they are sorted by the first seq
+	slices.Sort(names)
+
+	for _, name := range names {
+		s, records, err := loadSegment(filepath.Join(l.dir, name))
+		if err != nil {
+			return errors.Wrapf(err, "load segment %s", name)
+		}
+
+		if s.records > 0 {
+			l.nextSeq = max(l.nextSeq, s.lastSeq+1)
+		}
+
+		if len(s.acked) >= s.records {
+			if err := s.remove(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for _, r := range records {
+			if _, ok := s.acked[r.Seq]; !ok {
+				l.unacked = append(l.unacked, r)
+			}
+		}
+
+		l.segments = append(l.segments, s)
+	}
+
+	return nil
+}
+
+func loadSegment(path string) (*segment, []Record, error) {
+	s := &segment{
+		path:  path,
+		acked: make(map[uint64]struct{}),
+	}
+
+	records, err := readRecords(path + segmentExt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, r := range records {
+		if s.records == 0 {
+			s.firstSeq = r.Seq
+		}
+
+		s.lastSeq = r.Seq
+		s.records++
+	}
+
+	acked, err := readAcks(path + ackExt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, seq := range acked {
+		if seq >= s.firstSeq && seq <= s.lastSeq {
+			s.acked[seq] = struct{}{}
+		}
+	}
+
+	s.ackFile, err = os.OpenFile(path+ackExt, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "open ack file")
+	}
+
+	// cutting a torn tail off, otherwise new acks would be misaligned
+	if err := s.ackFile.Truncate(int64(8 * len(acked))); err != nil {
+		s.ackFile.Close()
+		return nil, nil, errors.Wrap(err, "truncate ack file")
+	}
+
+	return s, records, nil
+}
+
+// readRecords reads records of a segment.
+// A torn or corrupted tail left by a crash is ignored.
+func readRecords(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open segment")
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, headerSize)
+
+	var records []Record
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return records, nil
+		}
+
+		data := make([]byte, binary.LittleEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return records, nil
+		}
+
+		checksum := crc32.ChecksumIEEE(header[8:])
+		checksum = crc32.Update(checksum, crc32.IEEETable, data)
+
+		if checksum != binary.LittleEndian.Uint32(header[4:8]) {
+			return records, nil
+		}
+
+		records = append(records, Record{
+			Seq:  binary.LittleEndian.Uint64(header[8:16]),
+			Data: data,
+		})
+	}
+}
+
+func readAcks(path string) ([]uint64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "read ack file")
+	}
+
+	// a torn tail is ignored
+	acked := make([]uint64, 0, len(data)/8)
+
+	for i := 0; i+8 <= len(data); i += 8 {
+		acked = append(acked, binary.LittleEndian.Uint64(data[i:]))
+	}
+
+	return acked, nil
+}
+
+// truncate cuts a partially written record off the segment,
+// so records appended after it are not lost on load.
+func (s *segment) truncate() error {
+	if err := s.file.Truncate(s.size); err != nil {
+		return errors.Wrap(err, "truncate segment")
+	}
+
+	if _, err := s.file.Seek(s.size, io.SeekStart); err != nil {
+		return errors.Wrap(err, "seek segment")
+	}
+
+	return nil
+}
+
+func (s *segment) remove() error {
+	var errs []error
+
+	if s.file != nil {
+		errs = append(errs, s.file.Close())
+	}
+
+	errs = append(
+		errs,
+		s.ackFile.Close(),
+		os.Remove(s.path+segmentExt),
+		os.Remove(s.path+ackExt),
+	)
+
+	return stdErrors.Join(errs...)
+}
+
+func (l *Log) closeFiles() {
+	for _, s := range l.segments {
+		if s.file != nil {
+			s.file.Close()
+		}
+
+		if s.ackFile != nil {
+			s.ackFile.Close()
+		}
+	}
+}
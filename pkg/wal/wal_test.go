@@ -0,0 +1,254 @@
+package wal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogi-lyceya-44/common/pkg/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayUnacked(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	log, err := wal.Open(dir, wal.Options{SegmentSize: 64})
+	require.NoError(t, err)
+
+	var seqs []uint64
+
+	for _, data := range []string{"one", "two", "three", "four", "five"} {
+		seq, err := log.Append([]byte(data))
+		require.NoError(t, err)
+
+		seqs = append(seqs, seq)
+	}
+
+	require.NoError(t, log.Ack(seqs[0], seqs[2], seqs[4]))
+	require.NoError(t, log.Close())
+
+	log, err = wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	defer log.Close()
+
+	assert.Equal(
+		t,
+		[]wal.Record{
+			{Seq: seqs[1], Data: []byte("two")},
+			{Seq: seqs[3], Data: []byte("four")},
+		},
+		log.Unacked(),
+	)
+
+	seq, err := log.Append([]byte("six"))
+	require.NoError(t, err)
+	assert.Greater(t, seq, seqs[4])
+
+	require.NoError(t, log.Ack(seqs[1], seqs[3]))
+	assert.Empty(t, log.Unacked())
+}
+
+func TestAckAfterReopen(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	log, err := wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	seq, err := log.Append([]byte("one"))
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	log, err = wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, log.Ack(seq))
+	require.NoError(t, log.Close())
+
+	log, err = wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	defer log.Close()
+
+	assert.Empty(t, log.Unacked())
+}
+
+func TestRemoveAckedSegments(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// every record is larger than the segment size,
+	// so each one gets its own segment
+	log, err := wal.Open(dir, wal.Options{SegmentSize: 1})
+	require.NoError(t, err)
+
+	defer log.Close()
+
+	var seqs []uint64
+
+	for range 3 {
+		seq, err := log.Append([]byte("record"))
+		require.NoError(t, err)
+
+		seqs = append(seqs, seq)
+	}
+
+	require.NoError(t, log.Ack(seqs...))
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+
+	// only the active segment is left
+	assert.Len(t, segments, 1)
+}
+
+func TestTornTail(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	log, err := wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	seq, err := log.Append([]byte("whole"))
+	require.NoError(t, err)
+
+	_, err = log.Append([]byte("torn"))
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	info, err := os.Stat(segments[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(segments[0], info.Size()-2))
+
+	log, err = wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	defer log.Close()
+
+	assert.Equal(t, []wal.Record{{Seq: seq, Data: []byte("whole")}}, log.Unacked())
+}
+
+func TestAckUnknownSeq(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	log, err := wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	seq, err := log.Append([]byte("one"))
+	require.NoError(t, err)
+
+	// nothing is acknowledged if any seq is invalid
+	err = log.Ack(seq, seq+1)
+	require.ErrorIs(t, err, wal.ErrUnknownSeq)
+	require.NoError(t, log.Close())
+
+	log, err = wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	defer log.Close()
+
+	assert.Equal(t, []wal.Record{{Seq: seq, Data: []byte("one")}}, log.Unacked())
+}
+
+func TestAckRemovedSegment(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	log, err := wal.Open(dir, wal.Options{SegmentSize: 1})
+	require.NoError(t, err)
+
+	first, err := log.Append([]byte("one"))
+	require.NoError(t, err)
+
+	second, err := log.Append([]byte("two"))
+	require.NoError(t, err)
+
+	// the segment of the first record is removed
+	require.NoError(t, log.Ack(first))
+
+	require.NoError(t, log.Ack(first, second))
+	require.NoError(t, log.Close())
+
+	log, err = wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	defer log.Close()
+
+	assert.Empty(t, log.Unacked())
+}
+
+func TestTornAckTail(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	log, err := wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	first, err := log.Append([]byte("one"))
+	require.NoError(t, err)
+
+	second, err := log.Append([]byte("two"))
+	require.NoError(t, err)
+
+	// keeps the segment from being removed
+	third, err := log.Append([]byte("three"))
+	require.NoError(t, err)
+
+	require.NoError(t, log.Ack(first))
+	require.NoError(t, log.Close())
+
+	acks, err := filepath.Glob(filepath.Join(dir, "*.ack"))
+	require.NoError(t, err)
+	require.Len(t, acks, 1)
+
+	// a crash in the middle of an ack write
+	f, err := os.OpenFile(acks[0], os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	log, err = wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]wal.Record{{Seq: second, Data: []byte("two")}, {Seq: third, Data: []byte("three")}},
+		log.Unacked(),
+	)
+
+	// the ack is written after the torn tail is cut off
+	require.NoError(t, log.Ack(second))
+
+	log = reopen(t, log, dir)
+	defer log.Close()
+
+	assert.Equal(t, []wal.Record{{Seq: third, Data: []byte("three")}}, log.Unacked())
+}
+
+func reopen(t *testing.T, log *wal.Log, dir string) *wal.Log {
+	t.Helper()
+
+	require.NoError(t, log.Close())
+
+	log, err := wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	return log
+}
@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+const defaultAutoscaleCheckInterval = time.Second
+
+// AutoscaleConfig configures the autoscaler of the pool.
+//
+// On every check the autoscaler adds Step workers
+// if the queue depth is above QueueDepthThreshold
+// or a batch took longer than LatencyThreshold since the previous check.
+// If neither is true, the queue is empty, some workers are idle
+// and nothing was scaled for Cooldown, it removes Step workers.
+// The worker count always stays within [MinWorkers, MaxWorkers].
+type AutoscaleConfig struct {
+	MinWorkers int
+	MaxWorkers int
+
+	// CheckInterval is how often the autoscaler checks the load.
+	// Default is 1s.
+	CheckInterval time.Duration
+	// Cooldown is how long the pool must not be scaled
+	// before idle workers are removed.
+	Cooldown time.Duration
+	// Step is how many workers are added or removed at once.
+	// Default is 1.
+	Step int
+
+	// QueueDepthThreshold is the number of buffered items
+	// above which workers are added.
+	QueueDepthThreshold int
+	// LatencyThreshold is the batch processing time
+	// above which workers are added. Zero disables the check.
+	LatencyThreshold time.Duration
+}
+
+// WithAutoscaler enables the autoscaler with given config.
+// The initial worker count passed to New is clamped to the config bounds.
+func WithAutoscaler[T any](config AutoscaleConfig) Option[T] {
+	return func(w *WorkerPool[T]) {
+		config.MinWorkers = max(config.MinWorkers, 1)
+		config.MaxWorkers = max(config.MaxWorkers, config.MinWorkers)
+		config.Step = max(config.Step, 1)
+
+		if config.CheckInterval <= 0 {
+			config.CheckInterval = defaultAutoscaleCheckInterval
+		}
+
+		w.autoscale = &config
+	}
+}
+
+func (w *WorkerPool[T]) runAutoscaler(ctx context.Context) {
+	ticker := w.clock.NewTicker(w.autoscale.CheckInterval)
+	defer ticker.Stop()
+
+	lastScaledAt := w.clock.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			if w.autoscaleOnce(now.Sub(lastScaledAt)) {
+				lastScaledAt = now
+			}
+		}
+	}
+}
+
+// autoscaleOnce checks the load and resizes the pool if needed.
+// It reports whether the pool was resized.
+func (w *WorkerPool[T]) autoscaleOnce(sinceScaled time.Duration) bool {
+	cfg := w.autoscale
+
+	depth := w.QueueDepth()
+	latency := time.Duration(w.peakLatency.Swap(0))
+
+	overloaded := depth > cfg.QueueDepthThreshold ||
+		(cfg.LatencyThreshold > 0 && latency > cfg.LatencyThreshold)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current := w.workerCount
+	target := current
+
+	switch {
+	case overloaded:
+		target = min(current+cfg.Step, cfg.MaxWorkers)
+	case depth == 0 &&
+		int(w.busy.Load()) < current &&
+		sinceScaled >= cfg.Cooldown:
+		target = max(current-cfg.Step, cfg.MinWorkers)
+	}
+
+	if target == current {
+		return false
+	}
+
+	w.resize(target)
+
+	return true
+}
@@ -0,0 +1,185 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoscaleUp(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	release := make(chan struct{})
+
+	pool := worker.New(
+		100, 1, 1, time.Hour,
+		func(context.Context, []int) { <-release },
+		worker.WithClock[int](clk),
+		worker.WithFlushMode[int](worker.FlushOnMaxLatency),
+		worker.WithAutoscaler[int](worker.AutoscaleConfig{
+			MinWorkers:          1,
+			MaxWorkers:          3,
+			CheckInterval:       time.Second,
+			QueueDepthThreshold: 2,
+		}),
+	)
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+	defer close(release)
+
+	// workers are stuck, so the queue stays above the threshold
+	for i := range 10 {
+		pool.Send(i)
+	}
+
+	require.Eventually(
+		t,
+		func() bool {
+			clk.Advance(time.Second)
+			return pool.WorkerCount() == 3
+		},
+		waitTimeout,
+		time.Millisecond,
+	)
+
+	// the count never goes above the maximum
+	for range 5 {
+		clk.Advance(time.Second)
+		assert.Equal(t, 3, pool.WorkerCount())
+	}
+}
+
+func TestAutoscaleDown(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	c := newCollector[int]()
+
+	pool := worker.New(
+		100, 10, 1, time.Hour, c.process,
+		worker.WithClock[int](clk),
+		worker.WithFlushMode[int](worker.FlushOnMaxLatency),
+		worker.WithAutoscaler[int](worker.AutoscaleConfig{
+			MinWorkers:          1,
+			MaxWorkers:          3,
+			CheckInterval:       time.Second,
+			Cooldown:            2 * time.Second,
+			QueueDepthThreshold: 2,
+		}),
+	)
+
+	// the initial count is clamped to the maximum
+	assert.Equal(t, 3, pool.WorkerCount())
+
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+
+	require.Eventually(
+		t,
+		func() bool {
+			clk.Advance(time.Second)
+			return pool.WorkerCount() == 1
+		},
+		waitTimeout,
+		time.Millisecond,
+	)
+
+	// the count never goes below the minimum
+	for range 5 {
+		clk.Advance(time.Second)
+		assert.Equal(t, 1, pool.WorkerCount())
+	}
+
+	// the remaining worker still processes items
+	pool.Send(1)
+	assert.Equal(t, []int{1}, c.next(t))
+}
+
+func TestResizeClampedByAutoscaler(t *testing.T) {
+	t.Parallel()
+
+	pool := worker.New(
+		10, 1, 1, time.Hour,
+		func(context.Context, []int) {},
+		worker.WithAutoscaler[int](worker.AutoscaleConfig{
+			MinWorkers:    2,
+			MaxWorkers:    4,
+			CheckInterval: time.Hour,
+		}),
+	)
+
+	assert.Equal(t, 2, pool.WorkerCount())
+
+	require.NoError(t, pool.Resize(10))
+	assert.Equal(t, 4, pool.WorkerCount())
+
+	require.NoError(t, pool.Resize(1))
+	assert.Equal(t, 2, pool.WorkerCount())
+}
+
+func TestAutoscaleCheckIntervalDefault(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{
+			name:     "zero",
+			interval: 0,
+		},
+		{
+			name:     "negative",
+			interval: -time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			st.Parallel()
+
+			clk := clock.NewFake(time.Now())
+			release := make(chan struct{})
+
+			pool := worker.New(
+				100, 1, 1, time.Hour,
+				func(context.Context, []int) { <-release },
+				worker.WithClock[int](clk),
+				worker.WithFlushMode[int](worker.FlushOnMaxLatency),
+				worker.WithAutoscaler[int](worker.AutoscaleConfig{
+					MinWorkers:          1,
+					MaxWorkers:          2,
+					CheckInterval:       tc.interval,
+					QueueDepthThreshold: 2,
+				}),
+			)
+			pool.Start(context.Background())
+
+			defer pool.Stop()
+			defer close(release)
+
+			for i := range 10 {
+				pool.Send(i)
+			}
+
+			// the autoscaler checks every second by default
+			require.Eventually(
+				st,
+				func() bool {
+					clk.Advance(time.Second)
+					return pool.WorkerCount() == 2
+				},
+				waitTimeout,
+				time.Millisecond,
+			)
+		})
+	}
+}
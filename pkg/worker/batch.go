@@ -0,0 +1,123 @@
+package worker
+
+// KeyFunc returns the key by which items are coalesced.
+type KeyFunc[T any, K comparable] func(item T) K
+
+// MergeFunc merges an item into the pending item with the same key.
+type MergeFunc[T any] func(pending T, incoming T) T
+
+// WithCoalescing makes workers coalesce items with the same key
+// within a pending batch before it is processed.
+// The merged item keeps the position of the first item with that key.
+// If merge is nil, the last item wins.
+// The batch size limit applies to the number of distinct keys.
+func WithCoalescing[T any, K comparable](key KeyFunc[T, K], merge MergeFunc[T]) Option[T] {
+	if merge == nil {
+		merge = func(_ T, incoming T) T {
+			return incoming
+		}
+	}
+
+	return func(w *WorkerPool[T]) {
+		w.newBatch = func(capacity int) batch[T] {
+			return &coalescingBatch[T, K]{
+				sliceBatch: newSliceBatch[T](capacity),
+				index:      make(map[K]int, capacity),
+				key:        key,
+				merge:      merge,
+			}
+		}
+	}
+}
+
+// entry is an item on its way to a worker.
+type entry[T any] struct {
+	item T
+	// seq is the sequence number of the item in the durable queue,
+	// zero if the pool is not durable
+	seq uint64
+}
+
+// batch accumulates items of a worker until flush.
+type batch[T any] interface {
+	// add adds the entry and reports whether it was merged
+	// into an already pending one.
+	add(e entry[T]) bool
+	items() []T
+	// seqs returns sequence numbers of all added entries
+	// including merged ones.
+	seqs() []uint64
+	len() int
+	reset()
+}
+
+type sliceBatch[T any] struct {
+	pending   []T
+	sequences []uint64
+}
+
+func newSliceBatch[T any](capacity int) *sliceBatch[T] {
+	return &sliceBatch[T]{
+		pending: make([]T, 0, capacity),
+	}
+}
+
+func (b *sliceBatch[T]) add(e entry[T]) bool {
+	b.pending = append(b.pending, e.item)
+	b.addSeq(e.seq)
+
+	return false
+}
+
+func (b *sliceBatch[T]) addSeq(seq uint64) {
+	if seq != 0 {
+		b.sequences = append(b.sequences, seq)
+	}
+}
+
+func (b *sliceBatch[T]) items() []T {
+	return b.pending
+}
+
+func (b *sliceBatch[T]) seqs() []uint64 {
+	return b.sequences
+}
+
+func (b *sliceBatch[T]) len() int {
+	return len(b.pending)
+}
+
+func (b *sliceBatch[T]) reset() {
+	b.pending = b.pending[:0]
+	b.sequences = b.sequences[:0]
+}
+
+type coalescingBatch[T any, K comparable] struct {
+	*sliceBatch[T]
+
+	// index is a position of the pending item by its key
+	index map[K]int
+
+	key   KeyFunc[T, K]
+	merge MergeFunc[T]
+}
+
+func (b *coalescingBatch[T, K]) add(e entry[T]) bool {
+	key := b.key(e.item)
+
+	if i, ok := b.index[key]; ok {
+		b.pending[i] = b.merge(b.pending[i], e.item)
+		b.addSeq(e.seq)
+
+		return true
+	}
+
+	b.index[key] = len(b.pending)
+
+	return b.sliceBatch.add(e)
+}
+
+func (b *coalescingBatch[T, K]) reset() {
+	b.sliceBatch.reset()
+	clear(b.index)
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// workerHandle is used by the pool to control a worker.
+type workerHandle struct {
+	// quit is closed to remove the worker
+	quit chan struct{}
+	// flush receives requests to flush the pending batch,
+	// the passed channel is closed when the flush completes
+	flush chan chan struct{}
+	// done is closed when the worker exits
+	done chan struct{}
+}
+
+func newWorkerHandle() *workerHandle {
+	return &workerHandle{
+		quit:  make(chan struct{}),
+		flush: make(chan chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Pause stops processing batches.
+// Items are still accepted until the buffer is full,
+// and workers keep their pending batches until Resume.
+// Stop releases paused workers, so pending batches are flushed on shutdown.
+func (w *WorkerPool[T]) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.paused {
+		return
+	}
+
+	w.paused = true
+	w.resumed = make(chan struct{})
+}
+
+// Resume continues processing after Pause.
+func (w *WorkerPool[T]) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.paused {
+		return
+	}
+
+	w.paused = false
+	close(w.resumed)
+}
+
+// Paused reports whether the pool is paused.
+func (w *WorkerPool[T]) Paused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.paused
+}
+
+// Flush makes every worker process its pending batch right away
+// and waits until all these flushes complete or ctx is done.
+// Items still in the buffer are not flushed.
+// While the pool is paused, Flush waits for Resume.
+func (w *WorkerPool[T]) Flush(ctx context.Context) error {
+	w.mu.Lock()
+	handles := make([]*workerHandle, len(w.workers))
+	copy(handles, w.workers)
+	w.mu.Unlock()
+
+	requests := make([]chan struct{}, 0, len(handles))
+
+	for _, h := range handles {
+		done := make(chan struct{})
+
+		select {
+		case h.flush <- done:
+			requests = append(requests, done)
+		case <-h.done:
+			// the worker was removed and has flushed its batch on exit
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "request flush")
+		}
+	}
+
+	for _, done := range requests {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "wait for flush")
+		}
+	}
+
+	return nil
+}
+
+// waitResumed blocks while the pool is paused or until ctx is done.
+// It reports whether the worker was released by ctx rather than by Resume.
+func (w *WorkerPool[T]) waitResumed(ctx context.Context) bool {
+	w.mu.Lock()
+	paused, resumed := w.paused, w.resumed
+	w.mu.Unlock()
+
+	if !paused {
+		return false
+	}
+
+	select {
+	case <-resumed:
+		return false
+	case <-ctx.Done():
+		return true
+	}
+}
@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/bogi-lyceya-44/common/pkg/wal"
+	"github.com/pkg/errors"
+)
+
+// Codec converts items to bytes and back for the durable queue.
+type Codec[T any] interface {
+	Encode(item T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec is a Codec that uses encoding/json.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(item T) ([]byte, error) {
+	return json.Marshal(item)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var item T
+	err := json.Unmarshal(data, &item)
+
+	return item, err
+}
+
+// WithDurableQueue makes the pool append every accepted item
+// to the write-ahead log before it is buffered in memory.
+// An item is acknowledged in the log only after the batch containing it
+// is processed without a panic, so buffered items survive a crash.
+//
+// Items left unacknowledged by previous runs are sent to workers
+// on the first Start (into the first lane if lanes are used).
+// Items of batches that panicked are retried only after a restart.
+// Errors of the log are reported to the ErrorHandler;
+// an item that could not be appended is still processed.
+//
+// The pool does not own the log: close it after Stop.
+func WithDurableQueue[T any](log *wal.Log, codec Codec[T]) Option[T] {
+	return func(w *WorkerPool[T]) {
+		w.durable = log
+		w.codec = codec
+	}
+}
+
+// persist appends the item to the durable queue and returns its seq
+// or zero if the pool is not durable or the item could not be appended.
+func (w *WorkerPool[T]) persist(item T) uint64 {
+	if w.durable == nil {
+		return 0
+	}
+
+	data, err := w.codec.Encode(item)
+	if err != nil {
+		w.reportError(errors.Wrap(err, "encode item"))
+		return 0
+	}
+
+	seq, err := w.durable.Append(data)
+	if err != nil {
+		w.reportError(errors.Wrap(err, "append item to durable queue"))
+		return 0
+	}
+
+	return seq
+}
+
+func (w *WorkerPool[T]) ack(seqs []uint64) {
+	if w.durable == nil || len(seqs) == 0 {
+		return
+	}
+
+	if err := w.durable.Ack(seqs...); err != nil {
+		w.reportError(errors.Wrap(err, "ack items in durable queue"))
+	}
+}
+
+// loadReplay decodes items left unacknowledged by previous runs.
+// Items that can't be decoded are reported and acknowledged,
+// so they don't block the log forever.
+func (w *WorkerPool[T]) loadReplay() {
+	if w.durable == nil {
+		return
+	}
+
+	for _, record := range w.durable.Unacked() {
+		item, err := w.codec.Decode(record.Data)
+		if err != nil {
+			w.reportError(errors.Wrapf(err, "decode item %d", record.Seq))
+			w.ack([]uint64{record.Seq})
+
+			continue
+		}
+
+		w.replay = append(w.replay, entry[T]{
+			item: item,
+			seq:  record.Seq,
+		})
+	}
+
+	// replayed items are counted as undelivered before Start,
+	// so Drain waits for them even if it is called right after Start
+	w.undelivered.Add(int64(len(w.replay)))
+}
+
+// replayUnacked sends replayed items to workers until ctx is done.
+// Items that were not sent are kept for the next start
+// and stay counted as undelivered.
+func (w *WorkerPool[T]) replayUnacked(ctx context.Context) {
+	for len(w.replay) > 0 {
+		e := w.replay[0]
+
+		if len(w.lanes) > 0 {
+			select {
+			case w.lanes[0].items <- e:
+				w.notifyDispatcher()
+			case <-ctx.Done():
+				return
+			}
+		} else {
+			select {
+			case w.input <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		w.counters.received.Add(1)
+		w.replay = w.replay[1:]
+	}
+}
@@ -0,0 +1,87 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/wal"
+	"github.com/bogi-lyceya-44/common/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDurablePool(
+	t *testing.T,
+	log *wal.Log,
+	process worker.ProcessFunc[int],
+) *worker.WorkerPool[int] {
+	t.Helper()
+
+	return worker.New(
+		10, 1, 1, time.Hour, process,
+		worker.WithDurableQueue[int](log, worker.JSONCodec[int]{}),
+		// panics are expected
+		worker.WithErrorHandler[int](func(error) {}),
+	)
+}
+
+func reopen(t *testing.T, log *wal.Log, dir string) *wal.Log {
+	t.Helper()
+
+	require.NoError(t, log.Close())
+
+	log, err := wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	return log
+}
+
+func TestDurableQueue(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	log, err := wal.Open(dir, wal.Options{})
+	require.NoError(t, err)
+
+	c := newCollector[int]()
+
+	pool := newDurablePool(t, log, func(ctx context.Context, batch []int) {
+		if batch[0] == 2 {
+			panic("boom")
+		}
+
+		c.process(ctx, batch)
+	})
+	pool.Start(context.Background())
+
+	for i := range 4 {
+		pool.Send(i)
+	}
+
+	require.NoError(t, pool.Drain(context.Background()))
+
+	// only the batch that panicked is left unacknowledged
+	log = reopen(t, log, dir)
+
+	unacked := log.Unacked()
+	require.Len(t, unacked, 1)
+	assert.JSONEq(t, "2", string(unacked[0].Data))
+
+	c = newCollector[int]()
+
+	pool = newDurablePool(t, log, c.process)
+	pool.Start(context.Background())
+
+	// replayed items are counted before Start returns,
+	// so Drain right after it waits for them
+	require.NoError(t, pool.Drain(context.Background()))
+	require.Len(t, c.batches, 1)
+	assert.Equal(t, []int{2}, c.next(t))
+
+	log = reopen(t, log, dir)
+	defer log.Close()
+
+	assert.Empty(t, log.Unacked())
+}
@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidWorkerCount = errors.New("worker count must be positive")
+	ErrBatchTimeout       = errors.New("batch processing exceeded timeout")
+	ErrLaneNotFound       = errors.New("lane not found")
+	ErrInvalidLane        = errors.New("invalid lane")
+	ErrDuplicatePoolName  = errors.New("pool name is already registered")
+)
+
+// ErrorHandler receives errors that happen inside worker goroutines.
+// It is called concurrently from several workers.
+type ErrorHandler func(err error)
+
+// PanicError is reported to the ErrorHandler
+// when process panics while handling a batch.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("process panicked: %v\n%s", e.Value, e.Stack)
+}
@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Lane is a priority lane of the pool.
+// Lanes are passed in priority order: the first lane is the highest.
+type Lane struct {
+	Name string
+	// Weight is how many items of the lane are dispatched per round
+	// while it has items. Lower lanes still get their share every round,
+	// so they are slowed down but never starved. Default is 1.
+	Weight int
+	// BufferSize is the capacity of the lane.
+	// Default is inputBufferSize passed to New.
+	BufferSize int
+}
+
+// WithLanes splits the input of the pool into priority lanes.
+// Items are dispatched to workers by weighted round robin:
+// every round takes up to Weight items from each lane, highest lane first.
+// Send puts items into the last (lowest) lane, SendWithPriority into the named one.
+//
+// Invalid lanes are a programming error: WithLanes panics
+// with the error of ValidateLanes. Call ValidateLanes first
+// if lanes come from configuration.
+func WithLanes[T any](lanes ...Lane) Option[T] {
+	if err := ValidateLanes(lanes...); err != nil {
+		panic(err)
+	}
+
+	return func(w *WorkerPool[T]) {
+		w.laneConfig = lanes
+	}
+}
+
+// ValidateLanes returns ErrInvalidLane if a lane has an empty name
+// or names are not unique.
+func ValidateLanes(lanes ...Lane) error {
+	seen := make(map[string]struct{}, len(lanes))
+
+	for i, l := range lanes {
+		if l.Name == "" {
+			return errors.Wrapf(ErrInvalidLane, "lane %d has an empty name", i)
+		}
+
+		if _, ok := seen[l.Name]; ok {
+			return errors.Wrapf(ErrInvalidLane, "duplicate lane name %q", l.Name)
+		}
+
+		seen[l.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+type lane[T any] struct {
+	name   string
+	weight int
+	items  chan entry[T]
+}
+
+// initLanes builds lanes from the config.
+// When lanes are used, the input channel is unbuffered,
+// so the dispatcher decides which item goes next
+// at the moment a worker is ready to take it.
+func (w *WorkerPool[T]) initLanes(defaultBufferSize int) {
+	if len(w.laneConfig) == 0 {
+		return
+	}
+
+	w.input = make(chan entry[T])
+	w.laneSignal = make(chan struct{}, 1)
+	w.lanes = make([]*lane[T], 0, len(w.laneConfig))
+	w.laneByName = make(map[string]*lane[T], len(w.laneConfig))
+
+	for _, cfg := range w.laneConfig {
+		bufferSize := cfg.BufferSize
+		if bufferSize <= 0 {
+			bufferSize = defaultBufferSize
+		}
+
+		l := &lane[T]{
+			name:   cfg.Name,
+			weight: max(cfg.Weight, 1),
+			items:  make(chan entry[T], bufferSize),
+		}
+
+		w.lanes = append(w.lanes, l)
+		w.laneByName[l.name] = l
+	}
+}
+
+// SendWithPriority sends the item into the lane with given name.
+// If the pool has no such lane, it returns ErrLaneNotFound.
+func (w *WorkerPool[T]) SendWithPriority(laneName string, item T) error {
+	l, ok := w.laneByName[laneName]
+	if !ok {
+		return ErrLaneNotFound
+	}
+
+	w.sendToLane(l, entry[T]{
+		item: item,
+		seq:  w.persist(item),
+	})
+
+	return nil
+}
+
+func (w *WorkerPool[T]) sendToLane(l *lane[T], e entry[T]) {
+	w.undelivered.Add(1)
+	l.items <- e
+	w.counters.received.Add(1)
+
+	w.notifyDispatcher()
+}
+
+// notifyDispatcher wakes up the dispatcher if it waits for items.
+func (w *WorkerPool[T]) notifyDispatcher() {
+	select {
+	case w.laneSignal <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch moves items from lanes to workers until ctx is done.
+// An item taken from a lane but not handed to a worker
+// is kept and dispatched first on the next start.
+func (w *WorkerPool[T]) dispatch(ctx context.Context) {
+	credits := make([]int, len(w.lanes))
+
+	for {
+		if w.held == nil {
+			e, ok := w.nextFromLanes(ctx, credits)
+			if !ok {
+				return
+			}
+
+			w.held = &e
+		}
+
+		select {
+		case w.input <- *w.held:
+			w.held = nil
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// nextFromLanes takes the next item by weighted round robin.
+// It blocks until an item is available or ctx is done.
+func (w *WorkerPool[T]) nextFromLanes(ctx context.Context, credits []int) (entry[T], bool) {
+	for {
+		// the dispatcher is the only reader of lanes,
+		// so a non-empty lane can't become empty here
+		if i := w.pickLane(credits); i >= 0 {
+			credits[i]--
+			return <-w.lanes[i].items, true
+		}
+
+		select {
+		case <-w.laneSignal:
+		case <-ctx.Done():
+			return entry[T]{}, false
+		}
+	}
+}
+
+// pickLane returns the index of the highest non-empty lane
+// that has credits left in the current round or -1 if all lanes are empty.
+func (w *WorkerPool[T]) pickLane(credits []int) int {
+	for range 2 {
+		for i, l := range w.lanes {
+			if credits[i] > 0 && len(l.items) > 0 {
+				return i
+			}
+		}
+
+		// every non-empty lane has spent its share: start a new round
+		for i, l := range w.lanes {
+			credits[i] = l.weight
+		}
+	}
+
+	return -1
+}
+
+func (w *WorkerPool[T]) lanesDepth() int {
+	depth := 0
+
+	for _, l := range w.lanes {
+		depth += len(l.items)
+	}
+
+	return depth
+}
+
+func (w *WorkerPool[T]) lanesCapacity() int {
+	capacity := 0
+
+	for _, l := range w.lanes {
+		capacity += cap(l.items)
+	}
+
+	return capacity
+}
@@ -0,0 +1,143 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLanePool creates a pool with one worker that processes items one by one,
+// so the processing order is the dispatch order.
+func newLanePool(c *collector[string], lanes ...worker.Lane) *worker.WorkerPool[string] {
+	return worker.New(10, 1, 1, time.Hour, c.process, worker.WithLanes[string](lanes...))
+}
+
+// processed collects n items in the processing order.
+func processed(t *testing.T, c *collector[string], n int) []string {
+	t.Helper()
+
+	items := make([]string, 0, n)
+	for range n {
+		items = append(items, c.next(t)...)
+	}
+
+	return items
+}
+
+func TestWithLanesValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		lanes []worker.Lane
+	}{
+		{
+			name:  "empty name",
+			lanes: []worker.Lane{{Name: "high"}, {Name: ""}},
+		},
+		{
+			name:  "duplicate name",
+			lanes: []worker.Lane{{Name: "high"}, {Name: "low"}, {Name: "high"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(st *testing.T) {
+			st.Parallel()
+
+			require.ErrorIs(st, worker.ValidateLanes(tt.lanes...), worker.ErrInvalidLane)
+
+			assert.PanicsWithError(st, worker.ValidateLanes(tt.lanes...).Error(), func() {
+				worker.WithLanes[string](tt.lanes...)
+			})
+		})
+	}
+}
+
+func TestValidLanes(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, worker.ValidateLanes(worker.Lane{Name: "high"}, worker.Lane{Name: "low"}))
+}
+
+func TestSendWithPriorityUnknownLane(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[string]()
+	pool := newLanePool(c, worker.Lane{Name: "high"}, worker.Lane{Name: "low"})
+
+	err := pool.SendWithPriority("urgent", "item")
+	require.ErrorIs(t, err, worker.ErrLaneNotFound)
+	assert.Zero(t, pool.QueueDepth())
+}
+
+func TestSendUsesLowestLane(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[string]()
+	pool := newLanePool(c, worker.Lane{Name: "high"}, worker.Lane{Name: "low"})
+
+	pool.Send("plain")
+	require.NoError(t, pool.SendWithPriority("high", "urgent"))
+
+	pool.Start(context.Background())
+	defer pool.Stop()
+
+	assert.Equal(t, []string{"urgent", "plain"}, processed(t, c, 2))
+}
+
+func TestLanesWeightedRoundRobin(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[string]()
+	pool := newLanePool(
+		c,
+		worker.Lane{Name: "high", Weight: 3},
+		worker.Lane{Name: "low", Weight: 1},
+	)
+
+	// fill lanes before start, so every round sees both of them non-empty
+	for range 6 {
+		require.NoError(t, pool.SendWithPriority("high", "h"))
+	}
+
+	for range 2 {
+		require.NoError(t, pool.SendWithPriority("low", "l"))
+	}
+
+	pool.Start(context.Background())
+	defer pool.Stop()
+
+	assert.Equal(
+		t,
+		[]string{"h", "h", "h", "l", "h", "h", "h", "l"},
+		processed(t, c, 8),
+	)
+}
+
+func TestLowerLaneNotStarved(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[string]()
+	pool := newLanePool(
+		c,
+		worker.Lane{Name: "high", Weight: 2, BufferSize: 50},
+		worker.Lane{Name: "low"},
+	)
+
+	for range 50 {
+		require.NoError(t, pool.SendWithPriority("high", "h"))
+	}
+
+	require.NoError(t, pool.SendWithPriority("low", "l"))
+
+	pool.Start(context.Background())
+	defer pool.Stop()
+
+	// the saturated high lane gets only its weight before the low lane is served
+	assert.Contains(t, processed(t, c, 3), "l")
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Limiter limits the rate of batch processing.
+// It is implemented by *ratelimit.TokenBucket.
+type Limiter interface {
+	// Wait blocks until n tokens are taken or ctx is done.
+	Wait(ctx context.Context, n int) error
+}
+
+// RateLimitMode defines how many tokens a batch takes.
+type RateLimitMode int
+
+const (
+	// RateLimitBatches takes one token per batch.
+	RateLimitBatches RateLimitMode = iota
+	// RateLimitItems takes one token per item of the batch.
+	RateLimitItems
+)
+
+// WithRateLimit makes every worker wait for the limiter
+// before processing a batch. Share one limiter between workers
+// or even pools to get a common limit.
+// If the context is done while waiting, the error is reported
+// and the batch is processed right away, so no items are lost.
+func WithRateLimit[T any](limiter Limiter, mode RateLimitMode) Option[T] {
+	return func(w *WorkerPool[T]) {
+		w.limiter = limiter
+		w.limitMode = mode
+	}
+}
+
+func (w *WorkerPool[T]) waitLimiter(ctx context.Context, batchSize int) {
+	if w.limiter == nil {
+		return
+	}
+
+	tokens := 1
+	if w.limitMode == RateLimitItems {
+		tokens = batchSize
+	}
+
+	startedAt := w.clock.Now()
+	err := w.limiter.Wait(ctx, tokens)
+
+	w.counters.limiterWait.Add(int64(w.clock.Now().Sub(startedAt)))
+
+	if err != nil {
+		w.reportError(errors.Wrap(err, "wait for rate limiter"))
+	}
+}
@@ -0,0 +1,153 @@
+package worker_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/ratelimit"
+	"github.com/bogi-lyceya-44/common/pkg/worker"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingLimiter records requested tokens and never waits.
+type recordingLimiter struct {
+	mu     *sync.Mutex
+	tokens []int
+	err    error
+}
+
+func newRecordingLimiter(err error) *recordingLimiter {
+	return &recordingLimiter{
+		mu:  &sync.Mutex{},
+		err: err,
+	}
+}
+
+func (l *recordingLimiter) Wait(_ context.Context, n int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = append(l.tokens, n)
+
+	return l.err
+}
+
+func (l *recordingLimiter) requested() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]int(nil), l.tokens...)
+}
+
+func TestRateLimitMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		mode   worker.RateLimitMode
+		tokens []int
+	}{
+		{
+			name:   "batches",
+			mode:   worker.RateLimitBatches,
+			tokens: []int{1, 1},
+		},
+		{
+			name:   "items",
+			mode:   worker.RateLimitItems,
+			tokens: []int{3, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(st *testing.T) {
+			st.Parallel()
+
+			c := newCollector[int]()
+			limiter := newRecordingLimiter(nil)
+
+			pool := worker.New(
+				10, 1, 3, time.Hour, c.process,
+				worker.WithRateLimit[int](limiter, tt.mode),
+			)
+			pool.Start(context.Background())
+
+			defer pool.Stop()
+
+			for i := range 6 {
+				pool.Send(i)
+			}
+
+			assert.Equal(st, []int{0, 1, 2}, c.next(st))
+			assert.Equal(st, []int{3, 4, 5}, c.next(st))
+			assert.Equal(st, tt.tokens, limiter.requested())
+		})
+	}
+}
+
+func TestRateLimitThrottles(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	c := newCollector[int]()
+
+	// one batch per second without burst
+	limiter := ratelimit.NewTokenBucket(1, 1, ratelimit.WithClock(clk))
+
+	pool := worker.New(
+		10, 1, 1, time.Hour, c.process,
+		worker.WithClock[int](clk),
+		worker.WithRateLimit[int](limiter, worker.RateLimitBatches),
+	)
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+
+	pool.Send(1)
+	pool.Send(2)
+
+	assert.Equal(t, []int{1}, c.next(t))
+
+	// the second batch waits for a token
+	require.Eventually(
+		t,
+		func() bool { return pool.QueueDepth() == 0 },
+		waitTimeout,
+		time.Millisecond,
+	)
+	assert.Never(t, func() bool { return !c.empty() }, 50*time.Millisecond, time.Millisecond)
+
+	clk.Advance(time.Second)
+
+	assert.Equal(t, []int{2}, c.next(t))
+	assert.Equal(t, time.Second, pool.Stats().RateLimitWait)
+}
+
+func TestRateLimitErrorDoesNotLoseItems(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[int]()
+	errLimiter := errors.New("limiter is closed")
+	reported := make(chan error, 10)
+
+	pool := worker.New(
+		10, 1, 1, time.Hour, c.process,
+		worker.WithRateLimit[int](newRecordingLimiter(errLimiter), worker.RateLimitBatches),
+		worker.WithErrorHandler[int](func(err error) {
+			reported <- err
+		}),
+	)
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+
+	pool.Send(1)
+
+	assert.Equal(t, []int{1}, c.next(t))
+	require.ErrorIs(t, <-reported, errLimiter)
+}
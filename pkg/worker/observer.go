@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+// FlushInfo describes a single flush.
+type FlushInfo struct {
+	// Pool is the name of the pool set by WithName.
+	Pool      string
+	Reason    FlushReason
+	BatchSize int
+}
+
+// Observer is notified around every flush.
+// Methods are called from worker goroutines concurrently,
+// so implementations must be safe for concurrent use
+// and should not block.
+type Observer interface {
+	// BeforeFlush is called right before the batch is processed.
+	BeforeFlush(ctx context.Context, info FlushInfo)
+	// AfterFlush is called right after the batch is processed.
+	AfterFlush(ctx context.Context, info FlushInfo, elapsed time.Duration)
+}
+
+// WithObserver adds observers to the pool.
+func WithObserver[T any](observers ...Observer) Option[T] {
+	return func(w *WorkerPool[T]) {
+		w.observers = append(w.observers, observers...)
+	}
+}
+
+// WithName sets the name of the pool
+// that is reported in Stats and FlushInfo.
+func WithName[T any](name string) Option[T] {
+	return func(w *WorkerPool[T]) {
+		w.name = name
+	}
+}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+)
+
+// FlushMode defines when a partially filled batch is flushed.
+type FlushMode int
+
+const (
+	// FlushOnIdle flushes a batch when no new item
+	// has been received for the flush timeout.
+	// Under a steady trickle of items the batch may wait until it is full.
+	FlushOnIdle FlushMode = iota
+	// FlushOnMaxLatency flushes a batch when the flush timeout
+	// has passed since the first item was placed in it,
+	// so no item waits in a batch longer than the flush timeout.
+	FlushOnMaxLatency
+)
+
+type Option[T any] func(*WorkerPool[T])
+
+// WithFlushMode sets the flush mode of the pool.
+// Default is FlushOnIdle.
+func WithFlushMode[T any](mode FlushMode) Option[T] {
+	return func(w *WorkerPool[T]) {
+		w.flushMode = mode
+	}
+}
+
+// WithErrorHandler sets the handler of panics and slow batches.
+// Panics in process are always recovered, so the worker stays alive,
+// and are reported as *PanicError.
+func WithErrorHandler[T any](handler ErrorHandler) Option[T] {
+	return func(w *WorkerPool[T]) {
+		w.onError = handler
+	}
+}
+
+// WithBatchTimeout sets the deadline of the context passed to process.
+// Batches that take longer are reported to the ErrorHandler
+// as ErrBatchTimeout.
+func WithBatchTimeout[T any](timeout time.Duration) Option[T] {
+	return func(w *WorkerPool[T]) {
+		w.batchTimeout = timeout
+	}
+}
+
+// WithClock sets the clock used for flush timeouts,
+// the autoscaler and time measurements.
+// Default is clock.Real().
+func WithClock[T any](c clock.Clock) Option[T] {
+	return func(w *WorkerPool[T]) {
+		w.clock = c
+	}
+}
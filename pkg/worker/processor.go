@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// processorInitRetryDelay is the delay between attempts
+// to initialize a worker processor.
+const processorInitRetryDelay = time.Second
+
+// Processor handles batches of a single worker.
+// Each worker owns its processor, so it may keep per-worker state
+// like a dedicated connection or a reusable buffer without locking.
+type Processor[T any] interface {
+	// Init is called once when the worker starts.
+	// If it fails, the error is reported and Init is retried.
+	Init(ctx context.Context) error
+	// Process handles a batch, the batch must not be retained.
+	Process(ctx context.Context, batch []T)
+	// Close is called once when the worker stops
+	// after its last batch is processed.
+	Close() error
+}
+
+// ProcessorFactory creates a processor for a new worker.
+type ProcessorFactory[T any] func() Processor[T]
+
+// WithProcessorFactory makes every worker use its own processor
+// created by factory instead of the ProcessFunc passed to New,
+// which may be nil then.
+// Processors are created and closed as workers are started,
+// stopped, added or removed by resize.
+func WithProcessorFactory[T any](factory ProcessorFactory[T]) Option[T] {
+	return func(w *WorkerPool[T]) {
+		w.newProcessor = factory
+	}
+}
+
+func (f ProcessFunc[T]) Init(context.Context) error {
+	return nil
+}
+
+func (f ProcessFunc[T]) Process(ctx context.Context, batch []T) {
+	f(ctx, batch)
+}
+
+func (f ProcessFunc[T]) Close() error {
+	return nil
+}
+
+// initProcessor creates and initializes a processor of a worker.
+// It returns false if the worker was stopped before Init succeeded.
+func (w *WorkerPool[T]) initProcessor(
+	ctx context.Context,
+	quit <-chan struct{},
+) (Processor[T], bool) {
+	processor := w.newProcessor()
+
+	for {
+		err := processor.Init(ctx)
+		if err == nil {
+			return processor, true
+		}
+
+		w.reportError(errors.Wrap(err, "init processor"))
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-quit:
+			return nil, false
+		case <-w.clock.After(processorInitRetryDelay):
+		}
+	}
+}
+
+func (w *WorkerPool[T]) closeProcessor(processor Processor[T]) {
+	if err := processor.Close(); err != nil {
+		w.reportError(errors.Wrap(err, "close processor"))
+	}
+}
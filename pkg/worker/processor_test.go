@@ -0,0 +1,147 @@
+package worker_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/worker"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lifecycle counts processors created by its factory.
+type lifecycle struct {
+	initFailures atomic.Int64
+	inits        atomic.Int64
+	closes       atomic.Int64
+
+	processed chan int
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		processed: make(chan int, 100),
+	}
+}
+
+func (l *lifecycle) factory() worker.Processor[int] {
+	return &countingProcessor{lifecycle: l}
+}
+
+type countingProcessor struct {
+	lifecycle *lifecycle
+
+	// mu detects a processor shared between workers
+	mu *sync.Mutex
+}
+
+func (p *countingProcessor) Init(context.Context) error {
+	if p.lifecycle.initFailures.Add(-1) >= 0 {
+		return errors.New("database is down")
+	}
+
+	p.mu = &sync.Mutex{}
+	p.lifecycle.inits.Add(1)
+
+	return nil
+}
+
+func (p *countingProcessor) Process(_ context.Context, batch []int) {
+	if !p.mu.TryLock() {
+		panic("processor is used by two workers")
+	}
+	defer p.mu.Unlock()
+
+	for _, item := range batch {
+		p.lifecycle.processed <- item
+	}
+}
+
+func (p *countingProcessor) Close() error {
+	p.lifecycle.closes.Add(1)
+	return nil
+}
+
+func TestProcessorInitRetry(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	l := newLifecycle()
+	l.initFailures.Store(1)
+
+	reported := make(chan error, 10)
+
+	pool := worker.New[int](
+		10, 1, 1, time.Hour, nil,
+		worker.WithClock[int](clk),
+		worker.WithProcessorFactory(l.factory),
+		worker.WithErrorHandler[int](func(err error) {
+			reported <- err
+		}),
+	)
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+
+	pool.Send(1)
+
+	// the failed worker waits for the retry
+	clk.BlockUntil(1)
+	assert.Zero(t, l.inits.Load())
+	assert.ErrorContains(t, <-reported, "init processor")
+
+	clk.Advance(time.Second)
+
+	select {
+	case item := <-l.processed:
+		assert.Equal(t, 1, item)
+	case <-time.After(waitTimeout):
+		require.FailNow(t, "no item was processed")
+	}
+
+	assert.Equal(t, int64(1), l.inits.Load())
+}
+
+func TestProcessorPerWorker(t *testing.T) {
+	t.Parallel()
+
+	l := newLifecycle()
+
+	pool := worker.New[int](
+		10, 3, 1, time.Hour, nil,
+		worker.WithProcessorFactory(l.factory),
+	)
+	pool.Start(context.Background())
+
+	require.Eventually(
+		t,
+		func() bool { return l.inits.Load() == 3 },
+		waitTimeout,
+		time.Millisecond,
+	)
+
+	// removed workers close their processors
+	require.NoError(t, pool.Resize(1))
+
+	require.Eventually(
+		t,
+		func() bool { return l.closes.Load() == 2 },
+		waitTimeout,
+		time.Millisecond,
+	)
+
+	for i := range 5 {
+		pool.Send(i)
+	}
+
+	waitDelivered(t, pool)
+	pool.Stop()
+
+	assert.Len(t, l.processed, 5)
+	assert.Equal(t, int64(3), l.closes.Load())
+}
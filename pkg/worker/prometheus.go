@@ -0,0 +1,331 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// DefaultBatchSizeBuckets are the upper bounds of batch size histogram buckets.
+	DefaultBatchSizeBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000}
+	// DefaultDurationBuckets are the upper bounds (in seconds)
+	// of process duration histogram buckets.
+	DefaultDurationBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// PrometheusExporter exposes metrics of registered pools
+// in Prometheus text exposition format.
+// It is an Observer, so pass it to WithObserver
+// to collect batch size and process duration histograms.
+//
+// Example:
+//
+//	exporter := worker.NewPrometheusExporter("app")
+//	pool := worker.New(..., worker.WithName[Event]("events"), worker.WithObserver[Event](exporter))
+//	if err := exporter.Register(pool); err != nil {
+//		return err
+//	}
+//	http.Handle("/metrics", exporter)
+type PrometheusExporter struct {
+	namespace string
+
+	mu         *sync.Mutex
+	sources    []StatsSource
+	batchSizes map[string]*histogram
+	durations  map[string]*histogram
+}
+
+func NewPrometheusExporter(namespace string) *PrometheusExporter {
+	return &PrometheusExporter{
+		namespace:  namespace,
+		mu:         &sync.Mutex{},
+		batchSizes: make(map[string]*histogram),
+		durations:  make(map[string]*histogram),
+	}
+}
+
+// Register adds pools whose stats are exported as gauges and counters.
+// Series are labeled by pool name only, so it returns ErrDuplicatePoolName
+// and registers nothing if a name is already taken.
+func (e *PrometheusExporter) Register(sources ...StatsSource) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	names := make(map[string]struct{}, len(e.sources)+len(sources))
+
+	for _, source := range e.sources {
+		names[source.Stats().Name] = struct{}{}
+	}
+
+	for _, source := range sources {
+		name := source.Stats().Name
+		if _, ok := names[name]; ok {
+			return errors.Wrapf(ErrDuplicatePoolName, "pool %q", name)
+		}
+
+		names[name] = struct{}{}
+	}
+
+	e.sources = append(e.sources, sources...)
+
+	return nil
+}
+
+func (e *PrometheusExporter) BeforeFlush(context.Context, FlushInfo) {}
+
+func (e *PrometheusExporter) AfterFlush(_ context.Context, info FlushInfo, elapsed time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	batchSize, ok := e.batchSizes[info.Pool]
+	if !ok {
+		batchSize = newHistogram(DefaultBatchSizeBuckets)
+		e.batchSizes[info.Pool] = batchSize
+	}
+
+	duration, ok := e.durations[info.Pool]
+	if !ok {
+		duration = newHistogram(DefaultDurationBuckets)
+		e.durations[info.Pool] = duration
+	}
+
+	batchSize.observe(float64(info.BatchSize))
+	duration.observe(elapsed.Seconds())
+}
+
+func (e *PrometheusExporter) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	// metrics are rendered before the response is started,
+	// so a failure can still be reported with the status code
+	var body bytes.Buffer
+
+	if err := e.Export(&body); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	// the scraper is gone if the write fails, there is no one to report to
+	_, _ = body.WriteTo(rw)
+}
+
+// Export writes all metrics to out in Prometheus text exposition format.
+// Metrics are copied before writing, so a slow out does not block flushes.
+func (e *PrometheusExporter) Export(out io.Writer) error {
+	sources, batchSizes, durations := e.snapshot()
+
+	stats := make([]Stats, 0, len(sources))
+
+	for _, source := range sources {
+		stats = append(stats, source.Stats())
+	}
+
+	w := bufio.NewWriter(out)
+
+	gauges := []struct {
+		name  string
+		help  string
+		value func(Stats) float64
+	}{
+		{"queue_depth", "Number of items waiting in the input buffer.", func(s Stats) float64 { return float64(s.QueueDepth) }},
+		{"queue_capacity", "Capacity of the input buffer.", func(s Stats) float64 { return float64(s.QueueCapacity) }},
+		{"workers", "Current number of workers.", func(s Stats) float64 { return float64(s.Workers) }},
+		{"busy_workers", "Number of workers processing a batch.", func(s Stats) float64 { return float64(s.BusyWorkers) }},
+		{"utilization", "Share of workers processing a batch.", func(s Stats) float64 { return s.Utilization() }},
+	}
+
+	for _, g := range gauges {
+		name := e.metricName(g.name)
+		writeHeader(w, name, g.help, "gauge")
+
+		for _, s := range stats {
+			writeSample(w, name, g.value(s), "pool", s.Name)
+		}
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(Stats) float64
+	}{
+		{"items_received_total", "Total number of items sent to the pool.", func(s Stats) float64 { return float64(s.ItemsReceived) }},
+		{"items_processed_total", "Total number of items passed to process.", func(s Stats) float64 { return float64(s.ItemsProcessed) }},
+		{"items_coalesced_total", "Total number of items merged into pending items with the same key.", func(s Stats) float64 { return float64(s.ItemsCoalesced) }},
+		{"batches_total", "Total number of processed batches.", func(s Stats) float64 { return float64(s.Batches) }},
+		{"process_seconds_total", "Total time spent processing batches.", func(s Stats) float64 { return s.ProcessTime.Seconds() }},
+		{"rate_limit_wait_seconds_total", "Total time spent waiting for the rate limiter.", func(s Stats) float64 { return s.RateLimitWait.Seconds() }},
+		{"panics_total", "Total number of batches where process panicked.", func(s Stats) float64 { return float64(s.Panics) }},
+		{"slow_batches_total", "Total number of batches that exceeded the batch timeout.", func(s Stats) float64 { return float64(s.SlowBatches) }},
+	}
+
+	for _, c := range counters {
+		name := e.metricName(c.name)
+		writeHeader(w, name, c.help, "counter")
+
+		for _, s := range stats {
+			writeSample(w, name, c.value(s), "pool", s.Name)
+		}
+	}
+
+	flushes := e.metricName("flushes_total")
+	writeHeader(w, flushes, "Total number of flushes by reason.", "counter")
+
+	for _, s := range stats {
+		for reason := range flushReasonCount {
+			writeSample(w, flushes, float64(s.Flushes[reason]), "pool", s.Name, "reason", reason.String())
+		}
+	}
+
+	e.writeHistograms(w, "batch_size", "Number of items in processed batches.", batchSizes)
+	e.writeHistograms(w, "process_duration_seconds", "Time spent processing a batch.", durations)
+
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "write metrics")
+	}
+
+	return nil
+}
+
+// snapshot copies the registered sources and the histograms.
+func (e *PrometheusExporter) snapshot() (
+	sources []StatsSource,
+	batchSizes map[string]*histogram,
+	durations map[string]*histogram,
+) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return slices.Clone(e.sources), cloneHistograms(e.batchSizes), cloneHistograms(e.durations)
+}
+
+func (e *PrometheusExporter) writeHistograms(
+	w *bufio.Writer,
+	name string,
+	help string,
+	byPool map[string]*histogram,
+) {
+	name = e.metricName(name)
+	writeHeader(w, name, help, "histogram")
+
+	pools := make([]string, 0, len(byPool))
+
+	for pool := range byPool {
+		pools = append(pools, pool)
+	}
+
+	slices.Sort(pools)
+
+	for _, pool := range pools {
+		h := byPool[pool]
+
+		for i, bound := range h.bounds {
+			writeSample(w, name+"_bucket", float64(h.counts[i]), "pool", pool, "le", formatFloat(bound))
+		}
+
+		writeSample(w, name+"_bucket", float64(h.count), "pool", pool, "le", "+Inf")
+		writeSample(w, name+"_sum", h.sum, "pool", pool)
+		writeSample(w, name+"_count", float64(h.count), "pool", pool)
+	}
+}
+
+func (e *PrometheusExporter) metricName(name string) string {
+	if e.namespace == "" {
+		return "worker_" + name
+	}
+
+	return e.namespace + "_worker_" + name
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// writeSample writes a sample line, labels are passed as name-value pairs.
+func writeSample(w *bufio.Writer, name string, value float64, labels ...string) {
+	w.WriteString(name)
+
+	if len(labels) > 0 {
+		w.WriteByte('{')
+
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// histogram is a cumulative histogram with fixed buckets.
+// It is not safe for concurrent use.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) clone() *histogram {
+	return &histogram{
+		bounds: h.bounds,
+		counts: slices.Clone(h.counts),
+		count:  h.count,
+		sum:    h.sum,
+	}
+}
+
+func cloneHistograms(byPool map[string]*histogram) map[string]*histogram {
+	clones := make(map[string]*histogram, len(byPool))
+	for pool, h := range byPool {
+		clones[pool] = h.clone()
+	}
+
+	return clones
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.count++
+	h.sum += value
+}
@@ -0,0 +1,145 @@
+package worker_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/worker"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticStats worker.Stats
+
+func (s staticStats) Stats() worker.Stats {
+	return worker.Stats(s)
+}
+
+func TestPrometheusExporter(t *testing.T) {
+	t.Parallel()
+
+	exporter := worker.NewPrometheusExporter("app")
+	err := exporter.Register(staticStats{
+		Name:          `ev"ents`,
+		QueueDepth:    3,
+		QueueCapacity: 10,
+		Workers:       4,
+		BusyWorkers:   1,
+		Batches:       2,
+		Flushes: map[worker.FlushReason]uint64{
+			worker.FlushReasonSize: 2,
+		},
+		ProcessTime: 1500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	exporter.AfterFlush(
+		context.Background(),
+		worker.FlushInfo{Pool: "events", Reason: worker.FlushReasonSize, BatchSize: 7},
+		20*time.Millisecond,
+	)
+
+	rec := httptest.NewRecorder()
+	exporter.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	require.Equal(t, 200, rec.Code)
+
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE app_worker_queue_depth gauge\n",
+		`app_worker_queue_depth{pool="ev\"ents"} 3` + "\n",
+		`app_worker_utilization{pool="ev\"ents"} 0.25` + "\n",
+		`app_worker_process_seconds_total{pool="ev\"ents"} 1.5` + "\n",
+		`app_worker_flushes_total{pool="ev\"ents",reason="size"} 2` + "\n",
+		`app_worker_flushes_total{pool="ev\"ents",reason="timeout"} 0` + "\n",
+		"# TYPE app_worker_batch_size histogram\n",
+		`app_worker_batch_size_bucket{pool="events",le="5"} 0` + "\n",
+		`app_worker_batch_size_bucket{pool="events",le="10"} 1` + "\n",
+		`app_worker_batch_size_bucket{pool="events",le="+Inf"} 1` + "\n",
+		`app_worker_batch_size_sum{pool="events"} 7` + "\n",
+		`app_worker_process_duration_seconds_bucket{pool="events",le="0.025"} 1` + "\n",
+		`app_worker_process_duration_seconds_count{pool="events"} 1` + "\n",
+	} {
+		assert.Contains(t, body, want)
+	}
+}
+
+func TestSlowScrapeDoesNotBlockFlushes(t *testing.T) {
+	t.Parallel()
+
+	exporter := worker.NewPrometheusExporter("app")
+	info := worker.FlushInfo{Pool: "events", Reason: worker.FlushReasonSize, BatchSize: 1}
+
+	exporter.AfterFlush(context.Background(), info, time.Millisecond)
+
+	// nobody reads the pipe, so the export blocks on write
+	reader, writer := io.Pipe()
+	defer reader.Close()
+
+	go func() {
+		_ = exporter.Export(writer)
+	}()
+
+	flushed := make(chan struct{})
+
+	go func() {
+		defer close(flushed)
+		exporter.AfterFlush(context.Background(), info, time.Millisecond)
+	}()
+
+	select {
+	case <-flushed:
+	case <-time.After(waitTimeout):
+		require.FailNow(t, "flush is blocked by the export")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestExportWriteError(t *testing.T) {
+	t.Parallel()
+
+	exporter := worker.NewPrometheusExporter("app")
+
+	require.ErrorContains(t, exporter.Export(failingWriter{}), "connection reset")
+}
+
+func TestRegisterDuplicateName(t *testing.T) {
+	t.Parallel()
+
+	exporter := worker.NewPrometheusExporter("app")
+	require.NoError(t, exporter.Register(staticStats{Name: "events"}))
+
+	testCases := []struct {
+		name    string
+		sources []worker.StatsSource
+	}{
+		{
+			name:    "registered before",
+			sources: []worker.StatsSource{staticStats{Name: "orders"}, staticStats{Name: "events"}},
+		},
+		{
+			name:    "in the same call",
+			sources: []worker.StatsSource{staticStats{Name: "orders"}, staticStats{Name: "orders"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			err := exporter.Register(tc.sources...)
+			require.ErrorIs(st, err, worker.ErrDuplicatePoolName)
+		})
+	}
+
+	// nothing was registered by the failed calls
+	require.NoError(t, exporter.Register(staticStats{Name: "orders"}))
+}
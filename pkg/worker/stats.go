@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FlushReason describes why a batch was flushed.
+type FlushReason int
+
+const (
+	// FlushReasonSize means the batch reached the batch size.
+	FlushReasonSize FlushReason = iota
+	// FlushReasonTimeout means the flush timeout has expired.
+	FlushReasonTimeout
+	// FlushReasonShutdown means the worker was stopped
+	// by Stop or by scaling down.
+	FlushReasonShutdown
+	// FlushReasonManual means the batch was flushed by Flush.
+	FlushReasonManual
+
+	flushReasonCount
+)
+
+func (r FlushReason) String() string {
+	switch r {
+	case FlushReasonSize:
+		return "size"
+	case FlushReasonTimeout:
+		return "timeout"
+	case FlushReasonShutdown:
+		return "shutdown"
+	case FlushReasonManual:
+		return "manual"
+	default:
+		return "unknown"
+	}
+}
+
+// Stats is a point-in-time snapshot of the pool state.
+// Counters are cumulative since the pool was created.
+type Stats struct {
+	Name string
+
+	QueueDepth    int
+	QueueCapacity int
+
+	Workers     int
+	BusyWorkers int
+	Paused      bool
+
+	ItemsReceived  uint64
+	ItemsProcessed uint64
+	// ItemsCoalesced is the number of items merged
+	// into pending items with the same key.
+	ItemsCoalesced uint64
+	Batches        uint64
+	Flushes        map[FlushReason]uint64
+	Panics         uint64
+	SlowBatches    uint64
+
+	// ProcessTime is the total time spent in process by all workers.
+	ProcessTime time.Duration
+	// RateLimitWait is the total time workers waited for the rate limiter.
+	RateLimitWait time.Duration
+}
+
+// Utilization returns the share of workers that are processing a batch.
+func (s Stats) Utilization() float64 {
+	if s.Workers == 0 {
+		return 0
+	}
+
+	return float64(s.BusyWorkers) / float64(s.Workers)
+}
+
+// StatsSource is implemented by WorkerPool of any item type.
+type StatsSource interface {
+	Stats() Stats
+}
+
+type counters struct {
+	received    atomic.Uint64
+	processed   atomic.Uint64
+	coalesced   atomic.Uint64
+	batches     atomic.Uint64
+	flushes     [flushReasonCount]atomic.Uint64
+	processTime atomic.Int64
+	panics      atomic.Uint64
+	slowBatches atomic.Uint64
+	limiterWait atomic.Int64
+}
+
+// Stats returns a snapshot of the pool state.
+func (w *WorkerPool[T]) Stats() Stats {
+	flushes := make(map[FlushReason]uint64, flushReasonCount)
+
+	for reason := range flushReasonCount {
+		flushes[reason] = w.counters.flushes[reason].Load()
+	}
+
+	return Stats{
+		Name:           w.name,
+		QueueDepth:     w.QueueDepth(),
+		QueueCapacity:  w.QueueCapacity(),
+		Workers:        w.WorkerCount(),
+		BusyWorkers:    int(w.busy.Load()),
+		Paused:         w.Paused(),
+		ItemsReceived:  w.counters.received.Load(),
+		ItemsProcessed: w.counters.processed.Load(),
+		ItemsCoalesced: w.counters.coalesced.Load(),
+		Batches:        w.counters.batches.Load(),
+		Flushes:        flushes,
+		Panics:         w.counters.panics.Load(),
+		SlowBatches:    w.counters.slowBatches.Load(),
+		ProcessTime:    time.Duration(w.counters.processTime.Load()),
+		RateLimitWait:  time.Duration(w.counters.limiterWait.Load()),
+	}
+}
@@ -2,21 +2,71 @@ package worker
 
 import (
 	"context"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/wal"
+	"github.com/pkg/errors"
 )
 
 type ProcessFunc[T any] func(context.Context, []T)
 
 type WorkerPool[T any] struct {
-	input chan T
+	input chan entry[T]
 
 	workerCount  int
 	batchSize    int
 	flushTimeout time.Duration
-	process      ProcessFunc[T]
+	flushMode    FlushMode
+	newProcessor ProcessorFactory[T]
+	newBatch     func(capacity int) batch[T]
+
+	name         string
+	batchTimeout time.Duration
+	autoscale    *AutoscaleConfig
+	observers    []Observer
+	onError      ErrorHandler
+	clock        clock.Clock
+	limiter      Limiter
+	limitMode    RateLimitMode
+
+	laneConfig []Lane
+	lanes      []*lane[T]
+	laneByName map[string]*lane[T]
+	laneSignal chan struct{}
+	// held is an item taken from a lane by the dispatcher
+	// but not yet handed to a worker
+	held *entry[T]
+
+	durable *wal.Log
+	codec   Codec[T]
+	// replay is the items of the durable queue left from previous runs
+	// that are not sent to workers yet
+	replay []entry[T]
+
+	counters counters
+	// busy is the number of workers that are processing a batch right now
+	busy atomic.Int64
+	// undelivered is the number of accepted items
+	// that are not taken by workers yet
+	undelivered atomic.Int64
+	// delivered is signaled when undelivered drops to zero
+	delivered chan struct{}
+	// peakLatency is the longest batch processing time (ns)
+	// since it was read by the autoscaler last time
+	peakLatency atomic.Int64
 
-	cancel context.CancelFunc
+	mu      *sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers []*workerHandle
+
+	paused bool
+	// resumed is closed when the paused pool is resumed
+	resumed chan struct{}
 
 	once *sync.Once
 	wg   *sync.WaitGroup
@@ -28,16 +78,35 @@ func New[T any](
 	batchSize int,
 	flushTimeout time.Duration,
 	process ProcessFunc[T],
+	opts ...Option[T],
 ) *WorkerPool[T] {
-	return &WorkerPool[T]{
-		input:        make(chan T, inputBufferSize),
+	w := &WorkerPool[T]{
+		input:        make(chan entry[T], inputBufferSize),
 		workerCount:  workerCount,
 		flushTimeout: flushTimeout,
 		batchSize:    batchSize,
-		process:      process,
-		once:         &sync.Once{},
-		wg:           &sync.WaitGroup{},
+		newProcessor: func() Processor[T] {
+			return process
+		},
+		clock: clock.Real(),
+		newBatch: func(capacity int) batch[T] {
+			return newSliceBatch[T](capacity)
+		},
+		delivered: make(chan struct{}, 1),
+		mu:        &sync.Mutex{},
+		once:      &sync.Once{},
+		wg:        &sync.WaitGroup{},
+	}
+
+	for _, opt := range opts {
+		opt(w)
 	}
+
+	w.workerCount = w.clampWorkerCount(w.workerCount)
+	w.initLanes(inputBufferSize)
+	w.loadReplay()
+
+	return w
 }
 
 func (w *WorkerPool[T]) Start(ctx context.Context) {
@@ -46,73 +115,384 @@ func (w *WorkerPool[T]) Start(ctx context.Context) {
 
 func (w *WorkerPool[T]) start(ctx context.Context) {
 	w.once.Do(func() {
-		ctx, cancel := context.WithCancel(ctx)
-		w.cancel = cancel
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		w.ctx, w.cancel = context.WithCancel(ctx)
 
 		for range w.workerCount {
+			w.spawn()
+		}
+
+		if len(w.lanes) > 0 {
+			ctx := w.ctx
 			w.wg.Add(1)
 
 			go func() {
 				defer w.wg.Done()
-				w.work(ctx)
+				w.dispatch(ctx)
+			}()
+		}
+
+		if len(w.replay) > 0 {
+			ctx := w.ctx
+			w.wg.Add(1)
+
+			go func() {
+				defer w.wg.Done()
+				w.replayUnacked(ctx)
+			}()
+		}
+
+		if w.autoscale != nil {
+			ctx := w.ctx
+			w.wg.Add(1)
+
+			go func() {
+				defer w.wg.Done()
+				w.runAutoscaler(ctx)
 			}()
 		}
 	})
 }
 
-func (w *WorkerPool[T]) work(ctx context.Context) {
-	ticker := time.NewTicker(w.flushTimeout)
-	defer ticker.Stop()
+// Resize changes the number of workers at runtime.
+// Removed workers flush their pending batches before exiting,
+// so no accepted items are lost.
+// If the autoscaler is configured, n is clamped to its bounds.
+// If the pool is not started, the new count is used on Start.
+func (w *WorkerPool[T]) Resize(n int) error {
+	if n <= 0 {
+		return ErrInvalidWorkerCount
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	batch := make([]T, 0, w.batchSize)
+	w.resize(w.clampWorkerCount(n))
 
-	flush := func(flushCtx context.Context) {
-		if len(batch) == 0 {
+	return nil
+}
+
+// WorkerCount returns the current number of workers.
+func (w *WorkerPool[T]) WorkerCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.workerCount
+}
+
+// QueueDepth returns the number of items waiting in the input buffer.
+func (w *WorkerPool[T]) QueueDepth() int {
+	return len(w.input) + w.lanesDepth()
+}
+
+// QueueCapacity returns the capacity of the input buffer.
+func (w *WorkerPool[T]) QueueCapacity() int {
+	return cap(w.input) + w.lanesCapacity()
+}
+
+// resize must be called with w.mu held.
+func (w *WorkerPool[T]) resize(n int) {
+	w.workerCount = n
+
+	// the pool is not running, the count will be used on start
+	if w.ctx == nil {
+		return
+	}
+
+	for len(w.workers) < n {
+		w.spawn()
+	}
+
+	for len(w.workers) > n {
+		last := len(w.workers) - 1
+
+		close(w.workers[last].quit)
+		w.workers = w.workers[:last]
+	}
+}
+
+// spawn must be called with w.mu held.
+func (w *WorkerPool[T]) spawn() {
+	ctx, h := w.ctx, newWorkerHandle()
+	w.workers = append(w.workers, h)
+
+	w.wg.Add(1)
+
+	go func() {
+		defer w.wg.Done()
+		defer close(h.done)
+
+		w.work(ctx, h)
+	}()
+}
+
+func (w *WorkerPool[T]) clampWorkerCount(n int) int {
+	if w.autoscale == nil {
+		return n
+	}
+
+	return min(max(n, w.autoscale.MinWorkers), w.autoscale.MaxWorkers)
+}
+
+func (w *WorkerPool[T]) work(ctx context.Context, h *workerHandle) {
+	processor, ok := w.initProcessor(ctx, h.quit)
+	if !ok {
+		return
+	}
+
+	defer w.closeProcessor(processor)
+
+	timer := w.clock.NewTimer(w.flushTimeout)
+	defer timer.Stop()
+
+	if w.flushMode == FlushOnMaxLatency {
+		// the timer is armed by the first item of a batch
+		timer.Stop()
+	}
+
+	pending := w.newBatch(w.batchSize)
+
+	flush := func(flushCtx context.Context, reason FlushReason) {
+		if pending.len() == 0 {
 			return
 		}
 
-		w.process(flushCtx, batch)
-		batch = batch[:0]
+		// the pool context is used, so a paused worker is released by Stop,
+		// and then the batch is flushed like on shutdown
+		if w.waitResumed(ctx) {
+			flushCtx = context.Background()
+		}
+
+		w.processBatch(flushCtx, processor, pending, reason)
+		pending.reset()
+
+		if w.flushMode == FlushOnMaxLatency {
+			timer.Stop()
+		}
 	}
 
 	for {
 		select {
-		case <-ticker.C:
-			flush(ctx)
-		case item, ok := <-w.input:
+		case <-timer.C():
+			flush(ctx, FlushReasonTimeout)
+
+			if w.flushMode == FlushOnIdle {
+				timer.Reset(w.flushTimeout)
+			}
+		case e, ok := <-w.input:
 			if !ok {
-				flush(context.Background())
+				flush(context.Background(), FlushReasonShutdown)
 				return
 			}
 
-			ticker.Reset(w.flushTimeout)
-			batch = append(batch, item)
+			w.markDelivered(1)
+
+			switch w.flushMode {
+			case FlushOnIdle:
+				// the deadline is measured from the last received item
+				timer.Reset(w.flushTimeout)
+			case FlushOnMaxLatency:
+				// the deadline is measured from the first item of the batch
+				if pending.len() == 0 {
+					timer.Reset(w.flushTimeout)
+				}
+			}
+
+			if pending.add(e) {
+				w.counters.coalesced.Add(1)
+			}
 
-			if len(batch) >= w.batchSize {
-				flush(ctx)
+			if pending.len() >= w.batchSize {
+				flush(ctx, FlushReasonSize)
 			}
+		case done := <-h.flush:
+			flush(ctx, FlushReasonManual)
+			close(done)
+		case <-h.quit:
+			// the worker is removed by resize:
+			// the pool is still running, so the original context is fine
+			flush(ctx, FlushReasonShutdown)
+			return
 		case <-ctx.Done():
 			// for graceful shutdown:
 			// flush the leftovers with context.Background()
 			// since the original context is done
-			flush(context.Background())
+			flush(context.Background(), FlushReasonShutdown)
+			return
+		}
+	}
+}
+
+func (w *WorkerPool[T]) processBatch(
+	ctx context.Context,
+	processor Processor[T],
+	pending batch[T],
+	reason FlushReason,
+) {
+	batch := pending.items()
+
+	info := FlushInfo{
+		Pool:      w.name,
+		Reason:    reason,
+		BatchSize: len(batch),
+	}
+
+	w.waitLimiter(ctx, len(batch))
+
+	for _, observer := range w.observers {
+		observer.BeforeFlush(ctx, info)
+	}
+
+	w.busy.Add(1)
+	startedAt := w.clock.Now()
+
+	err := w.safeProcess(ctx, processor, batch)
+
+	elapsed := w.clock.Now().Sub(startedAt)
+	w.busy.Add(-1)
+
+	if err != nil {
+		w.counters.panics.Add(1)
+		w.reportError(err)
+	} else {
+		w.ack(pending.seqs())
+	}
+
+	if w.batchTimeout > 0 && elapsed > w.batchTimeout {
+		w.counters.slowBatches.Add(1)
+		w.reportError(errors.Wrapf(
+			ErrBatchTimeout,
+			"batch of %d items took %s",
+			len(batch),
+			elapsed,
+		))
+	}
+
+	w.observeLatency(elapsed)
+	w.counters.processed.Add(uint64(len(batch)))
+	w.counters.batches.Add(1)
+	w.counters.flushes[reason].Add(1)
+	w.counters.processTime.Add(int64(elapsed))
+
+	for _, observer := range w.observers {
+		observer.AfterFlush(ctx, info, elapsed)
+	}
+}
+
+// safeProcess calls the processor with the batch deadline applied
+// and converts a panic into *PanicError.
+func (w *WorkerPool[T]) safeProcess(
+	ctx context.Context,
+	processor Processor[T],
+	batch []T,
+) (err error) {
+	if w.batchTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, w.batchTimeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				Value: r,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+
+	processor.Process(ctx, batch)
+
+	return nil
+}
+
+func (w *WorkerPool[T]) reportError(err error) {
+	if w.onError != nil {
+		w.onError(err)
+	}
+}
+
+func (w *WorkerPool[T]) observeLatency(latency time.Duration) {
+	for {
+		peak := w.peakLatency.Load()
+		if int64(latency) <= peak || w.peakLatency.CompareAndSwap(peak, int64(latency)) {
 			return
 		}
 	}
 }
 
+// Drain waits until all accepted items are taken by workers
+// and then stops the pool, so pending batches are flushed.
+// A paused pool is resumed, otherwise its items could never be taken.
+// Items must not be sent while the pool is draining.
+// If ctx is done first, the pool is stopped anyway
+// and the remaining items are left in the buffer.
+func (w *WorkerPool[T]) Drain(ctx context.Context) error {
+	defer w.Stop()
+
+	w.Resume()
+
+	for w.undelivered.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "drain")
+		case <-w.delivered:
+		}
+	}
+
+	return nil
+}
+
+// markDelivered marks n items as taken by workers
+// and wakes up Drain when no items are left.
+func (w *WorkerPool[T]) markDelivered(n int64) {
+	if w.undelivered.Add(-n) > 0 {
+		return
+	}
+
+	select {
+	case w.delivered <- struct{}{}:
+	default:
+	}
+}
+
 func (w *WorkerPool[T]) Stop() {
-	if w.cancel != nil {
-		w.cancel()
+	w.mu.Lock()
+	cancel := w.cancel
+	w.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
 	}
 
 	w.wg.Wait()
 
+	w.mu.Lock()
+	w.ctx, w.cancel = nil, nil
+	w.workers = nil
+	w.mu.Unlock()
+
 	// create a new instance of once
 	// so we'll be able to start worker again
 	w.once = &sync.Once{}
 }
 
 func (w *WorkerPool[T]) Send(item T) {
-	w.input <- item
+	e := entry[T]{
+		item: item,
+		seq:  w.persist(item),
+	}
+
+	if len(w.lanes) > 0 {
+		// unprioritized items must not overtake prioritized ones
+		w.sendToLane(w.lanes[len(w.lanes)-1], e)
+		return
+	}
+
+	w.undelivered.Add(1)
+	w.input <- e
+	w.counters.received.Add(1)
 }
@@ -0,0 +1,371 @@
+package worker_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bogi-lyceya-44/common/pkg/clock"
+	"github.com/bogi-lyceya-44/common/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const waitTimeout = 5 * time.Second
+
+// collector records processed batches.
+type collector[T any] struct {
+	batches chan []T
+}
+
+func newCollector[T any]() *collector[T] {
+	return &collector[T]{
+		batches: make(chan []T, 100),
+	}
+}
+
+func (c *collector[T]) process(_ context.Context, batch []T) {
+	// the batch is reused by the worker after process returns
+	c.batches <- append([]T(nil), batch...)
+}
+
+func (c *collector[T]) next(t *testing.T) []T {
+	t.Helper()
+
+	select {
+	case batch := <-c.batches:
+		return batch
+	case <-time.After(waitTimeout):
+		require.FailNow(t, "no batch was processed")
+		return nil
+	}
+}
+
+func (c *collector[T]) empty() bool {
+	return len(c.batches) == 0
+}
+
+// waitDelivered waits until workers take all sent items.
+func waitDelivered[T any](t *testing.T, pool *worker.WorkerPool[T]) {
+	t.Helper()
+
+	require.Eventually(
+		t,
+		func() bool { return pool.QueueDepth() == 0 },
+		waitTimeout,
+		time.Millisecond,
+	)
+}
+
+func TestFlushOnSize(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[int]()
+	clk := clock.NewFake(time.Now())
+
+	pool := worker.New(10, 1, 3, time.Second, c.process, worker.WithClock[int](clk))
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+
+	for i := range 3 {
+		pool.Send(i)
+	}
+
+	assert.Equal(t, []int{0, 1, 2}, c.next(t))
+	assert.Equal(t, uint64(1), pool.Stats().Flushes[worker.FlushReasonSize])
+}
+
+func TestFlushOnIdle(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[int]()
+	clk := clock.NewFake(time.Now())
+
+	pool := worker.New(10, 1, 100, time.Second, c.process, worker.WithClock[int](clk))
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+
+	pool.Send(1)
+	waitDelivered(t, pool)
+
+	require.Eventually(
+		t,
+		func() bool {
+			clk.Advance(time.Second)
+			return !c.empty()
+		},
+		waitTimeout,
+		time.Millisecond,
+	)
+
+	assert.Equal(t, []int{1}, c.next(t))
+	assert.Equal(t, uint64(1), pool.Stats().Flushes[worker.FlushReasonTimeout])
+}
+
+func TestFlushOnMaxLatency(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[int]()
+	clk := clock.NewFake(time.Now())
+
+	pool := worker.New(
+		10, 1, 100, time.Second, c.process,
+		worker.WithClock[int](clk),
+		worker.WithFlushMode[int](worker.FlushOnMaxLatency),
+	)
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+
+	// a steady trickle of items keeps an idle timer from firing,
+	// but the deadline is measured from the first item
+	pool.Send(1)
+	waitDelivered(t, pool)
+	clk.BlockUntil(1)
+
+	for i := 2; i <= 3; i++ {
+		clk.Advance(400 * time.Millisecond)
+		pool.Send(i)
+		waitDelivered(t, pool)
+	}
+
+	assert.True(t, c.empty())
+
+	clk.Advance(400 * time.Millisecond)
+
+	assert.Equal(t, []int{1, 2, 3}, c.next(t))
+
+	// the timer is armed again only by the next item
+	assert.Equal(t, 0, clk.Waiters())
+}
+
+func TestCoalescing(t *testing.T) {
+	t.Parallel()
+
+	type change struct {
+		id      int
+		version int
+	}
+
+	c := newCollector[change]()
+
+	pool := worker.New(
+		10, 1, 100, time.Hour, c.process,
+		worker.WithCoalescing(
+			func(x change) int { return x.id },
+			func(pending, incoming change) change {
+				return change{id: pending.id, version: max(pending.version, incoming.version)}
+			},
+		),
+	)
+
+	for _, x := range []change{{1, 1}, {2, 1}, {1, 3}, {1, 2}} {
+		pool.Send(x)
+	}
+
+	pool.Start(context.Background())
+	require.NoError(t, pool.Drain(context.Background()))
+
+	assert.Equal(t, []change{{1, 3}, {2, 1}}, c.next(t))
+	assert.Equal(t, uint64(2), pool.Stats().ItemsCoalesced)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[int]()
+
+	var (
+		mu     sync.Mutex
+		errors []error
+	)
+
+	pool := worker.New(
+		10, 1, 1, time.Hour,
+		func(ctx context.Context, batch []int) {
+			if batch[0] == 0 {
+				panic("boom")
+			}
+
+			c.process(ctx, batch)
+		},
+		worker.WithErrorHandler[int](func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			errors = append(errors, err)
+		}),
+	)
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+
+	pool.Send(0)
+	pool.Send(1)
+
+	// the worker survives the panic and processes the next batch
+	assert.Equal(t, []int{1}, c.next(t))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, errors, 1)
+
+	var panicErr *worker.PanicError
+
+	require.ErrorAs(t, errors[0], &panicErr)
+	assert.Equal(t, "boom", panicErr.Value)
+	assert.Equal(t, uint64(1), pool.Stats().Panics)
+}
+
+func TestBatchTimeout(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Now())
+	reported := make(chan error, 10)
+
+	var deadlines []bool
+
+	pool := worker.New(
+		10, 1, 1, time.Hour,
+		func(ctx context.Context, batch []int) {
+			_, ok := ctx.Deadline()
+			deadlines = append(deadlines, ok)
+
+			// the batch takes longer than the timeout
+			if batch[0] == 0 {
+				clk.Advance(2 * time.Second)
+			}
+		},
+		worker.WithClock[int](clk),
+		worker.WithBatchTimeout[int](time.Second),
+		worker.WithErrorHandler[int](func(err error) {
+			reported <- err
+		}),
+	)
+	pool.Start(context.Background())
+
+	pool.Send(0)
+	pool.Send(1)
+
+	require.NoError(t, pool.Drain(context.Background()))
+
+	require.Len(t, reported, 1)
+	assert.ErrorIs(t, <-reported, worker.ErrBatchTimeout)
+	assert.Equal(t, []bool{true, true}, deadlines)
+	assert.Equal(t, uint64(1), pool.Stats().SlowBatches)
+}
+
+func TestResize(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[int]()
+
+	pool := worker.New(10, 2, 100, time.Hour, c.process)
+	pool.Start(context.Background())
+
+	pool.Send(1)
+	waitDelivered(t, pool)
+
+	// whichever worker holds the item, it is flushed
+	// either by the removed worker or by Stop
+	require.NoError(t, pool.Resize(1))
+	assert.Equal(t, 1, pool.WorkerCount())
+
+	pool.Stop()
+
+	assert.Equal(t, []int{1}, c.next(t))
+	assert.True(t, c.empty())
+	assert.Equal(t, uint64(1), pool.Stats().Flushes[worker.FlushReasonShutdown])
+
+	assert.ErrorIs(t, pool.Resize(0), worker.ErrInvalidWorkerCount)
+}
+
+func TestPauseResumeFlush(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[int]()
+
+	pool := worker.New(10, 1, 100, time.Hour, c.process)
+	pool.Start(context.Background())
+
+	defer pool.Stop()
+
+	pool.Pause()
+	assert.True(t, pool.Paused())
+
+	pool.Send(1)
+	pool.Send(2)
+	waitDelivered(t, pool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// the flush waits for resume
+	require.ErrorIs(t, pool.Flush(ctx), context.DeadlineExceeded)
+	assert.True(t, c.empty())
+
+	pool.Resume()
+
+	assert.Equal(t, []int{1, 2}, c.next(t))
+
+	pool.Send(3)
+	waitDelivered(t, pool)
+
+	require.NoError(t, pool.Flush(context.Background()))
+
+	// the flush has completed, so the batch is already processed
+	assert.False(t, c.empty())
+	assert.Equal(t, []int{3}, c.next(t))
+	assert.Equal(t, uint64(2), pool.Stats().Flushes[worker.FlushReasonManual])
+}
+
+func TestDrainPaused(t *testing.T) {
+	t.Parallel()
+
+	c := newCollector[int]()
+
+	pool := worker.New(10, 1, 1, time.Hour, c.process)
+	pool.Start(context.Background())
+
+	pool.Pause()
+
+	for i := range 5 {
+		pool.Send(i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	// the paused worker takes no more items until Drain resumes it
+	require.NoError(t, pool.Drain(ctx))
+	assert.False(t, pool.Paused())
+
+	for i := range 5 {
+		assert.Equal(t, []int{i}, c.next(t))
+	}
+}
+
+func TestStopFlushesPausedBatch(t *testing.T) {
+	t.Parallel()
+
+	ctxErrs := make(chan error, 10)
+
+	pool := worker.New(10, 1, 1, time.Hour, func(ctx context.Context, _ []int) {
+		ctxErrs <- ctx.Err()
+	})
+	pool.Start(context.Background())
+
+	pool.Pause()
+	pool.Send(1)
+	waitDelivered(t, pool)
+
+	pool.Stop()
+
+	// the batch held by the paused worker is processed with a live context
+	require.Len(t, ctxErrs, 1)
+	assert.NoError(t, <-ctxErrs)
+}